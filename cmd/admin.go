@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"miso/internal/module"
+	"miso/internal/registry"
+	"miso/internal/webhook"
+
+	"github.com/spf13/cobra"
+)
+
+func newPublishCmd(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "publish",
+		Short: "Upload a module archive or provider binary",
+	}
+
+	var meta module.VersionMetadata
+	moduleCmd := &cobra.Command{
+		Use:     "module <namespace>/<name>/<provider> <version> <module.zip>",
+		Short:   "Publish a module version from a zip archive",
+		Example: "  miso publish module acme/vpc/aws 1.2.0 ./vpc.zip --description \"Shared VPC\"",
+		Args:    cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parts, err := splitAddress(args[0], 3)
+			if err != nil {
+				return err
+			}
+			return opts.change(cmd.Context(), func(r *registry.Registry) (webhook.Event, error) {
+				e := webhook.Event{Type: webhook.ModulePublished, Kind: "module", Namespace: parts[0], Name: parts[1], Provider: parts[2], Version: args[1]}
+				return e, withFile(args[2], func(f io.Reader) error {
+					return r.PublishModule(parts[0], parts[1], parts[2], args[1], f, meta)
+				})
+			})
+		},
+	}
+	moduleCmd.Flags().StringVar(&meta.Owner, "owner", "", "owner of the module")
+	moduleCmd.Flags().StringVar(&meta.Description, "description", "", "short description of the module")
+	moduleCmd.Flags().StringVar(&meta.Source, "source", "", "source repository URL")
+	cmd.AddCommand(moduleCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:     "provider <namespace>/<type> <version> <os> <arch> <binary>",
+		Short:   "Publish a provider binary for one platform",
+		Example: "  miso publish provider acme/widget 0.4.1 linux amd64 ./terraform-provider-widget",
+		Args:    cobra.ExactArgs(5),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parts, err := splitAddress(args[0], 2)
+			if err != nil {
+				return err
+			}
+			return opts.change(cmd.Context(), func(r *registry.Registry) (webhook.Event, error) {
+				e := webhook.Event{Type: webhook.ProviderPublished, Kind: "provider", Namespace: parts[0], Name: parts[1], Version: args[1], OS: args[2], Arch: args[3]}
+				return e, withFile(args[4], func(f io.Reader) error {
+					return r.PublishProvider(parts[0], parts[1], args[1], args[2], args[3], f)
+				})
+			})
+		},
+	})
+
+	return cmd
+}
+
+func newListCmd(opts *globalOptions) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List modules or providers",
+	}
+	cmd.PersistentFlags().StringVarP(&output, "output", "o", "text", "output format: text or json")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "modules [namespace]",
+		Short: "List modules and their versions",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := opts.newRegistry(cmd.Context())
+			if err != nil {
+				return err
+			}
+			modules, err := r.ListModules(firstArg(args))
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return printJSON(cmd.OutOrStdout(), modules)
+			}
+			for _, m := range modules {
+				versions := make([]string, 0, len(m.Versions))
+				for _, v := range m.Versions {
+					versions = append(versions, v.Version)
+				}
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", m.ID(), strings.Join(versions, ","))
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "providers [namespace]",
+		Short: "List providers and their versions",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := opts.newRegistry(cmd.Context())
+			if err != nil {
+				return err
+			}
+			providers, err := r.ListProviders(firstArg(args))
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				return printJSON(cmd.OutOrStdout(), providers)
+			}
+			for _, p := range providers {
+				_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s/%s\t%s\n", p.Namespace, p.Type, strings.Join(p.Versions, ","))
+			}
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func newDeleteCmd(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete a module or provider version",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "module <namespace>/<name>/<provider> <version>",
+		Short: "Delete a module version",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parts, err := splitAddress(args[0], 3)
+			if err != nil {
+				return err
+			}
+			return opts.change(cmd.Context(), func(r *registry.Registry) (webhook.Event, error) {
+				e := webhook.Event{Type: webhook.VersionDeleted, Kind: "module", Namespace: parts[0], Name: parts[1], Provider: parts[2], Version: args[1]}
+				return e, r.DeleteModuleVersion(parts[0], parts[1], parts[2], args[1])
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "provider <namespace>/<type> <version>",
+		Short: "Delete a provider version for all platforms",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parts, err := splitAddress(args[0], 2)
+			if err != nil {
+				return err
+			}
+			return opts.change(cmd.Context(), func(r *registry.Registry) (webhook.Event, error) {
+				e := webhook.Event{Type: webhook.VersionDeleted, Kind: "provider", Namespace: parts[0], Name: parts[1], Version: args[1]}
+				return e, r.DeleteProviderVersion(parts[0], parts[1], args[1])
+			})
+		},
+	})
+
+	return cmd
+}
+
+func newYankCmd(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "yank",
+		Short: "Hide a module version from listings",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "module <namespace>/<name>/<provider> <version>",
+		Short: "Yank a module version, keeping it downloadable where pinned",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parts, err := splitAddress(args[0], 3)
+			if err != nil {
+				return err
+			}
+			return opts.change(cmd.Context(), func(r *registry.Registry) (webhook.Event, error) {
+				e := webhook.Event{Type: webhook.VersionYanked, Kind: "module", Namespace: parts[0], Name: parts[1], Provider: parts[2], Version: args[1]}
+				return e, r.YankModuleVersion(parts[0], parts[1], parts[2], args[1])
+			})
+		},
+	})
+
+	return cmd
+}
+
+func newReindexCmd(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "reindex",
+		Short: "Rebuild the registry index from a full storage scan",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			r, err := opts.newRegistry(cmd.Context())
+			if err != nil {
+				return err
+			}
+			index, err := r.Reindex()
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "indexed %d modules and %d providers\n", len(index.Modules), len(index.Providers))
+			return nil
+		},
+	}
+}
+
+func newVerifyCmd(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Check stored objects and the index for consistency",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			r, err := opts.newRegistry(cmd.Context())
+			if err != nil {
+				return err
+			}
+			problems, err := r.Verify()
+			if err != nil {
+				return err
+			}
+			for _, problem := range problems {
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), problem)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("found %d problems", len(problems))
+			}
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), "ok")
+			return nil
+		},
+	}
+}
+
+// splitAddress splits a registry address such as "acme/vpc/aws" into
+// exactly n non-empty parts.
+func splitAddress(address string, n int) ([]string, error) {
+	parts := strings.Split(address, "/")
+	if len(parts) != n {
+		return nil, fmt.Errorf("invalid address %q: expected %d parts separated by '/'", address, n)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid address %q: empty part", address)
+		}
+	}
+	return parts, nil
+}
+
+func withFile(path string, fn func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	return fn(f)
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
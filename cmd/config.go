@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"miso/internal/certs"
+	"miso/internal/config"
+	"miso/internal/download"
+	"miso/internal/security"
+	"miso/internal/storage/cdn"
+	"miso/internal/storage/s3"
+	"miso/internal/tracing"
+	"miso/internal/webhook"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+)
+
+// storageCheckTimeout bounds the bucket reachability check at startup.
+const storageCheckTimeout = 10 * time.Second
+
+func newConfigCmd(opts *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+
+	var skipStorage bool
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration and check that the bucket is reachable",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := opts.loadConfig()
+			if err != nil {
+				return err
+			}
+			if !skipStorage {
+				s3Storage, err := newStorage(cmd.Context(), cfg)
+				if err != nil {
+					return err
+				}
+				if err := checkStorage(cmd.Context(), s3Storage, cfg); err != nil {
+					return err
+				}
+			}
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), "configuration is valid")
+			return err
+		},
+	}
+	validateCmd.Flags().BoolVar(&skipStorage, "skip-storage", false, "only check the configuration, without contacting the bucket")
+	cmd.AddCommand(validateCmd)
+
+	return cmd
+}
+
+// printConfig writes the effective configuration as YAML, with secrets
+// redacted. It is not validated, so that invalid settings can be inspected.
+func printConfig(cmd *cobra.Command, opts *globalOptions) error {
+	cfg, err := config.LoadConfig(opts.configPaths...)
+	if err != nil {
+		return fmt.Errorf("could not load config: %w", err)
+	}
+	data, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		return err
+	}
+	if _, err := cmd.OutOrStdout().Write(data); err != nil {
+		return err
+	}
+	return errConfigPrinted
+}
+
+// validateConfig reports every invalid setting in cfg, including those
+// checked by the packages that own them.
+func validateConfig(cfg *config.Config) error {
+	errs := []error{cfg.Validate(), download.Validate(cfg.S3), tracing.Validate(cfg.Tracing), webhook.Validate(cfg.Webhooks), security.Validate(cfg.CORS, cfg.Security), certs.Validate(cfg.TLS)}
+	if cfg.CDN.Domain != "" {
+		errs = append(errs, cdn.Validate(cfg.CDN))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// setLogLevel applies a validated app.loglevel, defaulting to info.
+func setLogLevel(level *slog.LevelVar, name string) {
+	if name == "" {
+		level.Set(slog.LevelInfo)
+		return
+	}
+	_ = level.UnmarshalText([]byte(name))
+}
+
+// checkStorage verifies that the configured bucket is reachable.
+func checkStorage(ctx context.Context, s3Storage *s3.Storage, cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(ctx, storageCheckTimeout)
+	defer cancel()
+	if err := s3Storage.Ping(ctx); err != nil {
+		return fmt.Errorf("s3.bucket: bucket %q is not reachable: %w", cfg.S3.Bucket, err)
+	}
+	return nil
+}
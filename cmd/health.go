@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"miso/internal/config"
+	"miso/internal/health"
+	"miso/internal/registry"
+	"miso/internal/storage/s3"
+)
+
+// newChecker returns the readiness checks of the registry: bucket access and
+// the age of the registry index. A config change that could not be applied
+// is only logged and counted: it would fail every instance at once.
+func newChecker(cfg *config.Config, s3Storage *s3.Storage, r *registry.Registry, reloader *config.Reloader) *health.Checker {
+	checker := health.NewChecker(cfg.Health.CheckTimeout)
+	checker.Add("storage", func(ctx context.Context) error {
+		if err := s3Storage.Ping(ctx); err != nil {
+			return fmt.Errorf("bucket %q is not reachable: %w", cfg.S3.Bucket, err)
+		}
+		return nil
+	})
+	checker.Add("index", func(context.Context) error {
+		return checkIndex(r, reloader.Current().Health.IndexMaxAge)
+	})
+	return checker
+}
+
+// checkIndex fails when the registry index cannot be read or, with a
+// maximum age set, is missing or older than that.
+func checkIndex(r *registry.Registry, maxAge time.Duration) error {
+	info, err := r.IndexInfo()
+	if err != nil {
+		return err
+	}
+	if maxAge == 0 {
+		return nil
+	}
+	if info == nil {
+		return fmt.Errorf("no index has been generated, run miso reindex")
+	}
+	if age := time.Since(info.LastModified); age > maxAge {
+		return fmt.Errorf("index is %s old, more than %s", age.Round(time.Second), maxAge)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"miso/internal/handler"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newRequestLogger logs one line per request with its ID, outcome, timing,
+// route parameters and the principal and storage key recorded by handlers.
+func newRequestLogger(logger *slog.Logger) echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogRequestID:    true,
+		LogMethod:       true,
+		LogURI:          true,
+		LogStatus:       true,
+		LogLatency:      true,
+		LogResponseSize: true,
+		LogRemoteIP:     true,
+		LogError:        true,
+		HandleError:     true, // forwards error to the global error handler, so it can decide appropriate status code
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			attrs := []slog.Attr{
+				slog.String("request_id", v.RequestID),
+				slog.String("method", v.Method),
+				slog.String("uri", v.URI),
+				slog.Int("status", v.Status),
+				slog.Duration("latency", v.Latency),
+				slog.Int64("bytes", v.ResponseSize),
+				slog.String("remote_ip", v.RemoteIP),
+			}
+			for i, name := range c.ParamNames() {
+				if i < len(c.ParamValues()) && c.ParamValues()[i] != "" {
+					attrs = append(attrs, slog.String(name, c.ParamValues()[i]))
+				}
+			}
+			if span := trace.SpanContextFromContext(c.Request().Context()); span.IsValid() {
+				attrs = append(attrs, slog.String("trace_id", span.TraceID().String()))
+			}
+			if principal := handler.Principal(c); principal != "" {
+				attrs = append(attrs, slog.String("principal", principal))
+			}
+			if key := handler.StorageKey(c); key != "" {
+				attrs = append(attrs, slog.String("storage_key", key))
+			}
+
+			if v.Error == nil {
+				logger.LogAttrs(context.Background(), slog.LevelInfo, "REQUEST", attrs...)
+			} else {
+				attrs = append(attrs, slog.String("err", v.Error.Error()))
+				logger.LogAttrs(context.Background(), slog.LevelError, "REQUEST_ERROR", attrs...)
+			}
+			return nil
+		},
+	})
+}
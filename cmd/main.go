@@ -2,124 +2,152 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
-	"os/signal"
-	"time"
 
+	"miso/internal/audit"
 	"miso/internal/config"
-	"miso/internal/handler"
+	"miso/internal/registry"
+	"miso/internal/storage"
 	"miso/internal/storage/s3"
+	"miso/internal/webhook"
 
-	awsConfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/labstack/echo-contrib/echoprometheus"
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"github.com/spf13/cobra"
 )
 
-type Services struct {
-	Modules   string `json:"modules.v1"`
-	Providers string `json:"providers.v1"`
+// globalOptions holds the flags shared by every subcommand.
+type globalOptions struct {
+	configPaths []string
+	printConfig bool
 }
 
-func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+// errConfigPrinted stops the command after --print-config.
+var errConfigPrinted = errors.New("config printed")
 
-	config, err := config.LoadConfig()
-	if err != nil {
-		logger.Error("Could not load config")
+func main() {
+	err := newRootCmd().ExecuteContext(context.Background())
+	if err != nil && !errors.Is(err, errConfigPrinted) {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
+}
 
-	sdkConfig, err := awsConfig.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		logger.Error("Couldn't load default configuration. Have you set up your AWS account?")
-	}
-	storage := s3.New(config.S3, sdkConfig)
-
-	requestLoggerConfig := middleware.RequestLoggerConfig{
-		LogStatus:   true,
-		LogURI:      true,
-		LogError:    true,
-		HandleError: true, // forwards error to the global error handler, so it can decide appropriate status code
-		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			if v.Error == nil {
-				logger.LogAttrs(
-					context.Background(), slog.LevelInfo, "REQUEST",
-					slog.String("uri", v.URI),
-					slog.Int("status", v.Status),
-				)
-			} else {
-				logger.LogAttrs(
-					context.Background(), slog.LevelError, "REQUEST_ERROR",
-					slog.String("uri", v.URI),
-					slog.Int("status", v.Status),
-					slog.String("err", v.Error.Error()),
-				)
+func newRootCmd() *cobra.Command {
+	opts := &globalOptions{}
+	var bindErr error
+
+	cmd := &cobra.Command{
+		Use:           "miso",
+		Short:         "Terraform module and provider registry",
+		Long:          "Terraform module and provider registry. Without a subcommand, miso starts the servers.",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			if bindErr != nil {
+				return fmt.Errorf("could not bind flags: %w", bindErr)
+			}
+			if opts.printConfig {
+				return printConfig(cmd, opts)
 			}
 			return nil
 		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return serve(cmd.Context(), opts)
+		},
 	}
+	cmd.PersistentFlags().StringSliceVar(&opts.configPaths, "config", nil, "additional directories to search for config.yaml")
+	cmd.PersistentFlags().BoolVar(&opts.printConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	bindErr = config.BindFlags(cmd.PersistentFlags())
+
+	cmd.AddCommand(
+		newServeCmd(opts),
+		newPublishCmd(opts),
+		newListCmd(opts),
+		newDeleteCmd(opts),
+		newYankCmd(opts),
+		newReindexCmd(opts),
+		newVerifyCmd(opts),
+		newConfigCmd(opts),
+		newAuditCmd(opts),
+	)
+
+	return cmd
+}
 
-	// Main server
-	mainServer := echo.New()
-	mainServer.HideBanner = true
-	mainServer.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
-		AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
-	}))
-	mainServer.Use(middleware.RequestLoggerWithConfig(requestLoggerConfig))
-	mainServer.Use(middleware.Recover())
-	mainServer.GET("/", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, true)
-	})
-
-	mainServer.Static("/.well-known", "public/.well-known")
-
-	// Register v1 handler
-	v1 := mainServer.Group("/v1")
-	h := handler.NewHandler(storage, config.S3)
-	h.Register(v1)
-
-	// Health Rerver
-	healthServer := echo.New()
-	healthServer.HideBanner = true
-	healthServer.Use(middleware.RequestLoggerWithConfig(requestLoggerConfig))
-	healthServer.Use(middleware.Recover())
-	healthServer.Use(echoprometheus.NewMiddleware("miso"))
-
-	healthServer.GET("/health", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, true)
-	})
-	healthServer.GET("/metrics", echoprometheus.NewHandler())
-
-	// Run all
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
-
-	go func() {
-		if err := mainServer.Start(config.App.Host + ":" + config.App.Port); err != nil && err != http.ErrServerClosed {
-			mainServer.Logger.Error("shutting down the server")
-		}
-	}()
-
-	go func() {
-		if err := healthServer.Start(config.App.Host + ":" + config.Metrics.Port); err != nil && err != http.ErrServerClosed {
-			healthServer.Logger.Error("shutting down the server")
-		}
-	}()
+// loadConfig reads the configuration from the configured search paths and
+// validates it.
+func (o *globalOptions) loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(o.configPaths...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config: %w", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	<-ctx.Done()
+// newStorage builds the S3 storage backend for cfg.
+func newStorage(ctx context.Context, cfg *config.Config) (*s3.Storage, error) {
+	sdkConfig, err := s3.LoadAWSConfig(ctx, cfg.S3)
+	if err != nil {
+		return nil, fmt.Errorf("could not load AWS configuration: %w", err)
+	}
+	return s3.New(cfg.S3, sdkConfig), nil
+}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// newRegistry loads the configuration and returns a registry on top of the
+// configured storage, for use by the admin subcommands.
+func (o *globalOptions) newRegistry(ctx context.Context) (*registry.Registry, error) {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := openRegistry(ctx, cfg)
+	return r, err
+}
 
-	if err := mainServer.Shutdown(shutdownCtx); err != nil {
-		mainServer.Logger.Error(err)
+// openRegistry returns a registry on top of the storage configured in cfg,
+// and the bucket beneath it.
+func openRegistry(ctx context.Context, cfg *config.Config) (*registry.Registry, *s3.Storage, error) {
+	s3Storage, err := newStorage(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
 	}
+	var storage storage.Storage = s3Storage
+	if cfg.Audit.Enabled {
+		log, err := newAuditLog(cfg.Audit, s3Storage)
+		if err != nil {
+			return nil, nil, err
+		}
+		storage = audit.NewStorage(s3Storage, log, slog.Default()).WithContext(audit.WithActor(ctx, cliActor()))
+	}
+	return registry.New(storage), s3Storage, nil
+}
 
-	if err := healthServer.Shutdown(shutdownCtx); err != nil {
-		healthServer.Logger.Error(err)
+// change runs fn against the registry, then sends the event it returns to
+// the configured webhooks as the admin API would, and waits for the
+// deliveries before returning.
+func (o *globalOptions) change(ctx context.Context, fn func(r *registry.Registry) (webhook.Event, error)) error {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
 	}
+	r, s3Storage, err := openRegistry(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	e, err := fn(r)
+	if err != nil || len(cfg.Webhooks.Hooks) == 0 {
+		return err
+	}
+
+	dispatcher := webhook.NewDispatcher(cfg.Webhooks, s3Storage, slog.Default())
+	e.Principal = cliActor().Principal
+	err = dispatcher.Publish(e)
+	dispatcher.Drain()
+	return err
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"miso/internal/audit"
+	"miso/internal/certs"
+	"miso/internal/config"
+	"miso/internal/download"
+	"miso/internal/handler"
+	"miso/internal/ratelimit"
+	"miso/internal/registry"
+	"miso/internal/security"
+	"miso/internal/stats"
+	"miso/internal/storage"
+	"miso/internal/storage/cache"
+	"miso/internal/storage/cdn"
+	"miso/internal/storage/instrument"
+	"miso/internal/tracing"
+	"miso/internal/webhook"
+
+	"github.com/labstack/echo-contrib/echoprometheus"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/spf13/cobra"
+)
+
+type Services struct {
+	Modules   string `json:"modules.v1"`
+	Providers string `json:"providers.v1"`
+}
+
+func newServeCmd(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Start the registry and health servers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return serve(cmd.Context(), opts)
+		},
+	}
+}
+
+func serve(ctx context.Context, opts *globalOptions) error {
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	logLevel := new(slog.LevelVar)
+	setLogLevel(logLevel, cfg.App.LogLevel)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	slog.SetDefault(logger)
+
+	s3Storage, err := newStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if err := checkStorage(ctx, s3Storage, cfg); err != nil {
+		return err
+	}
+	// The audit trail and download statistics are written below the audit
+	// and tracing layers, so that their own writes are not audited.
+	internal := instrument.New(s3Storage, logger)
+	var storage storage.Storage = internal
+	if cfg.Cache.Enabled {
+		storage, err = cache.New(storage, cfg.Cache.Dir, cfg.Cache.MaxSizeMB<<20)
+		if err != nil {
+			return fmt.Errorf("could not open download cache: %w", err)
+		}
+	}
+	var cdnStorage *cdn.Storage
+	if cfg.CDN.Domain != "" {
+		cdnStorage, err = cdn.New(storage, cfg.CDN, cfg.S3.PresignExpiry)
+		if err != nil {
+			return fmt.Errorf("invalid cdn settings: %w", err)
+		}
+		storage = cdnStorage
+	}
+	var auditLog *audit.Log
+	if cfg.Audit.Enabled {
+		auditLog, err = newAuditLog(cfg.Audit, internal)
+		if err != nil {
+			return err
+		}
+		storage = audit.NewStorage(storage, auditLog, logger)
+	}
+	storage = tracing.NewStorage(storage, cfg.S3.Bucket)
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("could not flush traces", slog.String("err", err.Error()))
+		}
+	}()
+
+	// Serve over TLS when a certificate is configured
+	var mainTLS, healthTLS *tls.Config
+	if cfg.TLS.CertFile != "" {
+		certReloader, err := certs.NewReloader(cfg.TLS, logger)
+		if err != nil {
+			return fmt.Errorf("could not load certificates: %w", err)
+		}
+		mainTLS = certReloader.ServerConfig(cfg.TLS.ClientAuth != "")
+		healthTLS = certReloader.ServerConfig(false)
+	}
+
+	// Main server
+	mainServer := echo.New()
+	mainServer.HideBanner = true
+	// Download policies and rate limits match on the client address, so
+	// only trust X-Forwarded-For when it was set by a configured proxy.
+	mainServer.IPExtractor = security.IPExtractor(cfg.Security.TrustedProxies)
+	mainServer.Use(security.Headers(cfg.Security))
+	mainServer.Use(security.CORS(cfg.CORS, "/v1/admin")...)
+	mainServer.Use(middleware.RequestID())
+	mainServer.Use(clientCertAuth)
+	mainServer.Use(tracing.Middleware())
+	mainServer.Use(newRequestLogger(logger))
+	mainServer.Use(echoprometheus.NewMiddleware("miso"))
+	mainServer.Use(middleware.Recover())
+	mainServer.GET("/", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, true)
+	})
+
+	mainServer.Static("/.well-known", "public/.well-known")
+
+	// Register v1 handler
+	v1 := mainServer.Group("/v1")
+	h := handler.NewHandler(storage, cfg.S3)
+	h.Logger = logger
+	h.Audit = auditLog
+	if cfg.Cache.ListTTL > 0 {
+		h.Registry.Versions = registry.NewVersionCache(cfg.Cache.ListTTL)
+	}
+
+	// Record downloads for the statistics endpoints
+	var recorder *stats.Recorder
+	if cfg.Stats.Enabled {
+		h.Stats = stats.NewStore(internal)
+		recorder = stats.NewRecorder(h.Stats, cfg.Stats.BatchSize, cfg.Stats.FlushInterval, logger)
+		h.Recorder = recorder
+	}
+
+	// Notify webhooks of publications and deletions
+	dispatcher := webhook.NewDispatcher(cfg.Webhooks, internal, logger)
+	h.Webhooks = dispatcher
+
+	// Limit each client, by principal or else by address, per route class
+	h.Middleware = make(map[handler.RouteClass][]echo.MiddlewareFunc)
+	for class, limit := range map[handler.RouteClass]config.Limit{
+		handler.RoutesList:     cfg.RateLimit.List,
+		handler.RoutesDownload: cfg.RateLimit.Download,
+		handler.RoutesPublish:  cfg.RateLimit.Publish,
+	} {
+		if limiter := ratelimit.New(string(class), limit, clientKey); limiter != nil {
+			h.Middleware[class] = []echo.MiddlewareFunc{limiter.Middleware()}
+		}
+	}
+	h.MaxStreams = cfg.RateLimit.MaxProxyStreams
+	h.Register(v1)
+
+	// Reload the settings that are safe to change while serving
+	reloader := config.NewReloader(cfg, validateConfig, logger)
+	secret := cfg.App.Secret
+	reloader.OnReload(func(cfg *config.Config) {
+		setLogLevel(logLevel, cfg.App.LogLevel)
+		h.SetPolicy(download.NewPolicy(cfg.S3))
+		s3Storage.SetPresignExpiry(cfg.S3.PresignExpiry)
+		if cdnStorage != nil {
+			cdnStorage.SetExpiry(cfg.S3.PresignExpiry)
+		}
+		if auditLog != nil && cfg.App.Secret != secret {
+			if err := recordKeyRotation(auditLog); err != nil {
+				logger.Error("could not record audit event", slog.String("action", audit.ActionKeyRotate), slog.String("err", err.Error()))
+			}
+		}
+		secret = cfg.App.Secret
+		dispatcher.SetHooks(cfg.Webhooks.Hooks)
+	})
+	reloader.Watch()
+
+	// Admin routes reject every key while no secret is configured, and
+	// skip the key for the client certificates listed in tls.admin_principals
+	admin := v1.Group("/admin", middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		Skipper: func(c echo.Context) bool {
+			principal := certs.ClientPrincipal(c)
+			return principal != "" && slices.Contains(reloader.Current().TLS.AdminPrincipals, principal)
+		},
+		Validator: func(key string, c echo.Context) (bool, error) {
+			secret := reloader.Current().App.Secret
+			if secret == "" || subtle.ConstantTimeCompare([]byte(key), []byte(secret)) != 1 {
+				return false, nil
+			}
+			handler.SetPrincipal(c, "admin")
+			return true, nil
+		},
+	}), middleware.BodyLimit(fmt.Sprintf("%dM", cfg.App.MaxUploadSizeMB)))
+	h.RegisterAdmin(admin)
+
+	// Health Rerver
+	healthServer := echo.New()
+	healthServer.HideBanner = true
+	healthServer.Use(newRequestLogger(logger))
+	healthServer.Use(middleware.Recover())
+
+	checker := newChecker(cfg, s3Storage, registry.New(internal), reloader)
+	healthServer.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, true)
+	})
+	healthServer.GET("/livez", checker.Livez)
+	healthServer.GET("/readyz", checker.Readyz)
+	healthServer.GET("/metrics", echoprometheus.NewHandler())
+
+	// Run all until SIGTERM, an interrupt, or a server failing to start
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErrs := make(chan error, 2)
+	go func() {
+		if err := start(mainServer, cfg.App.Host+":"+cfg.App.Port, mainTLS); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErrs <- fmt.Errorf("main server: %w", err)
+		}
+	}()
+
+	go func() {
+		if err := start(healthServer, cfg.App.Host+":"+cfg.Metrics.Port, healthTLS); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErrs <- fmt.Errorf("health server: %w", err)
+		}
+	}()
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-serverErrs:
+	}
+	// A second signal stops the process without draining.
+	stop()
+
+	// Fail readiness first so that load balancers stop sending requests,
+	// then let the in-flight ones finish
+	checker.ShutDown()
+	app := reloader.Current().App
+	if serveErr == nil && app.DrainPeriod > 0 {
+		logger.Info("draining", slog.Duration("drain_period", app.DrainPeriod))
+		time.Sleep(app.DrainPeriod)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	if streams := h.ActiveStreams(); streams > 0 {
+		logger.Info("waiting for proxied downloads", slog.Int64("streams", streams))
+	}
+	if err := mainServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("could not finish in-flight requests",
+			slog.Int64("interrupted_streams", h.ActiveStreams()),
+			slog.String("err", err.Error()),
+		)
+	}
+
+	if err := healthServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("could not stop health server", slog.String("err", err.Error()))
+	}
+
+	// Write the downloads recorded and deliver the events published before
+	// the servers stopped
+	if recorder != nil {
+		recorder.Close()
+	}
+	dispatcher.Close()
+
+	return serveErr
+}
+
+// start serves e on address, over TLS when tlsConfig is set.
+func start(e *echo.Echo, address string, tlsConfig *tls.Config) error {
+	if tlsConfig == nil {
+		return e.Start(address)
+	}
+	e.TLSServer.Addr = address
+	e.TLSServer.TLSConfig = tlsConfig
+	return e.StartServer(e.TLSServer)
+}
+
+// clientCertAuth authenticates requests that come with a verified client
+// certificate as the principal of its subject.
+func clientCertAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if principal := certs.ClientPrincipal(c); principal != "" {
+			handler.SetPrincipal(c, principal)
+		}
+		return next(c)
+	}
+}
+
+// clientKey tells clients apart for rate limiting.
+func clientKey(c echo.Context) string {
+	if principal := handler.Principal(c); principal != "" {
+		return "principal:" + principal
+	}
+	return "ip:" + c.RealIP()
+}
@@ -0,0 +1,95 @@
+// Package audit keeps a tamper-evident trail of write and admin operations.
+// Every event carries the hash of the event before it, so removing or
+// editing a stored event breaks the chain from that point on.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	ActionPut       = "storage.put"
+	ActionDelete    = "storage.delete"
+	ActionKeyRotate = "key.rotate"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is one audited operation.
+type Event struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	SourceIP  string    `json:"source_ip,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	// ContentHash is the hex SHA-256 of the written content.
+	ContentHash string `json:"content_hash,omitempty"`
+	PrevHash    string `json:"prev_hash"`
+	Hash        string `json:"hash"`
+}
+
+// chainHash returns the hash of e linked to the hash of its predecessor.
+func (e Event) chainHash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(e.PrevHash+"\n"), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify checks that events, ordered by sequence number, form an unbroken
+// chain. The first event may link to an event that is not part of events.
+func Verify(events []Event) error {
+	for i, e := range events {
+		hash, err := e.chainHash()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("event %d: hash mismatch", e.Seq)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := events[i-1]
+		if e.Seq != prev.Seq+1 {
+			return fmt.Errorf("event %d: follows event %d", e.Seq, prev.Seq)
+		}
+		if e.PrevHash != prev.Hash {
+			return fmt.Errorf("event %d: does not link to event %d", e.Seq, prev.Seq)
+		}
+	}
+	return nil
+}
+
+// Actor is who an operation is performed for.
+type Actor struct {
+	Principal string
+	SourceIP  string
+	RequestID string
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx carrying actor.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor in ctx.
+func ActorFrom(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
@@ -0,0 +1,173 @@
+package audit_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"miso/internal/audit"
+	"miso/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) audit.Store{
+		"bucket": func(t *testing.T) audit.Store {
+			return audit.NewBucketStore(storage.NewMemoryStorage())
+		},
+		"file": func(t *testing.T) audit.Store {
+			return audit.NewFileStore(filepath.Join(t.TempDir(), "audit.jsonl"))
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+			log, err := audit.Open(store)
+			require.NoError(t, err)
+			require.NoError(t, log.Record(audit.Event{Time: start, Actor: "ci", Action: audit.ActionPut, Target: "a"}))
+			require.NoError(t, log.Record(audit.Event{Time: start.Add(24 * time.Hour), Actor: "admin", Action: audit.ActionDelete, Target: "b"}))
+
+			// A reopened log continues the chain.
+			log, err = audit.Open(store)
+			require.NoError(t, err)
+			require.NoError(t, log.Record(audit.Event{Time: start.Add(48 * time.Hour), Actor: "ci", Action: audit.ActionPut, Target: "c"}))
+
+			events, err := log.Query(audit.Filter{})
+			require.NoError(t, err)
+			require.Len(t, events, 3)
+			assert.Equal(t, []uint64{1, 2, 3}, []uint64{events[0].Seq, events[1].Seq, events[2].Seq})
+			assert.Empty(t, events[0].PrevHash)
+			assert.NoError(t, audit.Verify(events))
+
+			events, err = log.Query(audit.Filter{Actor: "ci"})
+			require.NoError(t, err)
+			require.Len(t, events, 2)
+			assert.Equal(t, "c", events[1].Target)
+
+			events, err = log.Query(audit.Filter{From: start.Add(time.Hour), To: start.Add(47 * time.Hour)})
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			assert.Equal(t, "b", events[0].Target)
+		})
+	}
+}
+
+func TestBucketStoreWriters(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	server, err := audit.Open(audit.NewBucketStore(s))
+	require.NoError(t, err)
+	cli, err := audit.Open(audit.NewBucketStore(s))
+	require.NoError(t, err)
+
+	// Each log appends after the other has, from a stale view of the last
+	// event.
+	for i := range 3 {
+		require.NoError(t, server.Record(audit.Event{Actor: "admin", Action: audit.ActionPut, Target: fmt.Sprintf("server-%d", i)}))
+		require.NoError(t, cli.Record(audit.Event{Actor: "cli:ops", Action: audit.ActionPut, Target: fmt.Sprintf("cli-%d", i)}))
+	}
+
+	events, err := server.Query(audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, events, 6)
+	assert.NoError(t, audit.Verify(events))
+	assert.Equal(t, uint64(6), events[5].Seq)
+
+	t.Run("open-reads-head", func(t *testing.T) {
+		list := s.ListFunc
+		lists := 0
+		s.ListFunc = func(prefix string) ([]string, error) {
+			lists++
+			return list(prefix)
+		}
+		defer func() { s.ListFunc = list }()
+
+		log, err := audit.Open(audit.NewBucketStore(s))
+		require.NoError(t, err)
+		require.NoError(t, log.Record(audit.Event{Actor: "admin", Action: audit.ActionDelete, Target: "a"}))
+		assert.Zero(t, lists)
+	})
+
+	t.Run("without-head", func(t *testing.T) {
+		// Trails written before the head object existed are scanned once.
+		require.NoError(t, s.Delete("audit/head.json"))
+		log, err := audit.Open(audit.NewBucketStore(s))
+		require.NoError(t, err)
+		require.NoError(t, log.Record(audit.Event{Actor: "admin", Action: audit.ActionDelete, Target: "b"}))
+
+		events, err := log.Query(audit.Filter{})
+		require.NoError(t, err)
+		require.Len(t, events, 8)
+		assert.NoError(t, audit.Verify(events))
+	})
+}
+
+func TestVerify(t *testing.T) {
+	log, err := audit.Open(audit.NewFileStore(filepath.Join(t.TempDir(), "audit.jsonl")))
+	require.NoError(t, err)
+	for _, target := range []string{"a", "b", "c"} {
+		require.NoError(t, log.Record(audit.Event{Actor: "ci", Action: audit.ActionPut, Target: target}))
+	}
+	events, err := log.Query(audit.Filter{})
+	require.NoError(t, err)
+	require.NoError(t, audit.Verify(events))
+
+	t.Run("edited", func(t *testing.T) {
+		edited := append([]audit.Event(nil), events...)
+		edited[1].Actor = "someone-else"
+		assert.EqualError(t, audit.Verify(edited), "event 2: hash mismatch")
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		assert.EqualError(t, audit.Verify([]audit.Event{events[0], events[2]}), "event 3: follows event 1")
+	})
+
+	t.Run("tail", func(t *testing.T) {
+		assert.NoError(t, audit.Verify(events[1:]))
+	})
+}
+
+func TestStorage(t *testing.T) {
+	log, err := audit.Open(audit.NewFileStore(filepath.Join(t.TempDir(), "audit.jsonl")))
+	require.NoError(t, err)
+
+	backend := storage.NewMemoryStorage()
+	backend.DeleteFunc = func(key string) error {
+		return errors.New("access denied")
+	}
+	ctx := audit.WithActor(context.Background(), audit.Actor{Principal: "admin", SourceIP: "10.0.0.1", RequestID: "req-1"})
+	s := audit.NewStorage(backend, log, slog.Default()).WithContext(ctx)
+
+	require.NoError(t, s.Put("modules/a/b/c/1.0.0/module.zip", strings.NewReader("archive")))
+	require.Error(t, s.Delete("modules/a/b/c/1.0.0/module.zip"))
+	_, err = s.GetBuffer("modules/a/b/c/1.0.0/module.zip")
+	require.NoError(t, err)
+
+	events, err := log.Query(audit.Filter{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	sum := sha256.Sum256([]byte("archive"))
+	assert.Equal(t, "admin", events[0].Actor)
+	assert.Equal(t, "10.0.0.1", events[0].SourceIP)
+	assert.Equal(t, "req-1", events[0].RequestID)
+	assert.Equal(t, audit.ActionPut, events[0].Action)
+	assert.Equal(t, "modules/a/b/c/1.0.0/module.zip", events[0].Target)
+	assert.Equal(t, audit.OutcomeSuccess, events[0].Outcome)
+	assert.Equal(t, hex.EncodeToString(sum[:]), events[0].ContentHash)
+
+	assert.Equal(t, audit.ActionDelete, events[1].Action)
+	assert.Equal(t, audit.OutcomeFailure, events[1].Outcome)
+	assert.Equal(t, "access denied", events[1].Error)
+}
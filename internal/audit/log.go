@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var eventsRecorded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "miso_audit_events_total",
+	Help: "Audit events by result of writing them.",
+}, []string{"result"})
+
+// ErrConflict is returned by Store.Append when another writer appended an
+// event first.
+var ErrConflict = errors.New("another writer appended to the audit trail first")
+
+// appendAttempts bounds how often Record retries after another writer
+// appended first.
+const appendAttempts = 10
+
+// Store persists audit events. Stores only ever add events.
+type Store interface {
+	// Append stores e, which must directly follow the last stored event.
+	Append(e Event) error
+	// Last returns the event with the highest sequence number, or nil when
+	// the store is empty.
+	Last() (*Event, error)
+	// Read returns the events in the given time range, ordered by sequence
+	// number. A zero bound is open.
+	Read(from, to time.Time) ([]Event, error)
+}
+
+// Log appends events to a store, chaining each to the one before it. When
+// another writer sharing the store appended first, the event is chained to
+// that writer's event instead.
+type Log struct {
+	store Store
+
+	mu   sync.Mutex
+	last Event
+}
+
+// Open returns a log that continues the chain in store.
+func Open(store Store) (*Log, error) {
+	l := &Log{store: store}
+	last, err := store.Last()
+	if err != nil {
+		return nil, err
+	}
+	if last != nil {
+		l.last = *last
+	}
+	return l, nil
+}
+
+// Record completes e with its time, sequence number and hashes and appends
+// it to the store.
+func (l *Log) Record(e Event) (err error) {
+	defer func() {
+		result := "written"
+		if err != nil {
+			result = "failed"
+		}
+		eventsRecorded.WithLabelValues(result).Inc()
+	}()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	for attempt := 1; ; attempt++ {
+		e.Seq = l.last.Seq + 1
+		e.PrevHash = l.last.Hash
+		if e.Hash, err = e.chainHash(); err != nil {
+			return err
+		}
+		err = l.store.Append(e)
+		if !errors.Is(err, ErrConflict) || attempt == appendAttempts {
+			break
+		}
+		last, err := l.store.Last()
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			l.last = *last
+		}
+	}
+	if err != nil {
+		return err
+	}
+	l.last = e
+
+	return nil
+}
+
+// follows reports whether e directly follows last, which is nil for an
+// empty store.
+func follows(e Event, last *Event) bool {
+	if last == nil {
+		return e.Seq == 1
+	}
+	return e.Seq == last.Seq+1 && e.PrevHash == last.Hash
+}
+
+// Filter selects events in Query. Zero fields match every event.
+type Filter struct {
+	From  time.Time
+	To    time.Time
+	Actor string
+}
+
+// Query returns the events matching f, ordered by sequence number.
+func (l *Log) Query(f Filter) ([]Event, error) {
+	events, err := l.store.Read(f.From, f.To)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := events[:0]
+	for _, e := range events {
+		if f.Actor == "" || e.Actor == f.Actor {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+// inRange reports whether t is within the range from..to, where a zero bound
+// is open.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+}
+
+func sortBySeq(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Seq < events[j].Seq
+	})
+}
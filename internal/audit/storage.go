@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+
+	"miso/internal/storage"
+)
+
+// Storage records an audit event for every Put and Delete on the wrapped
+// backend, attributed to the actor of the context given to WithContext.
+// Reads pass through unaudited.
+type Storage struct {
+	storage.Storage
+
+	log    *Log
+	logger *slog.Logger
+	ctx    context.Context
+}
+
+func NewStorage(backend storage.Storage, log *Log, logger *slog.Logger) *Storage {
+	return &Storage{Storage: backend, log: log, logger: logger, ctx: context.Background()}
+}
+
+// WithContext returns a copy of s that attributes its writes to the actor
+// in ctx.
+func (s *Storage) WithContext(ctx context.Context) storage.Storage {
+	scoped := *s
+	scoped.ctx = ctx
+	if backend, ok := s.Storage.(storage.ContextStorage); ok {
+		scoped.Storage = backend.WithContext(ctx)
+	}
+	return &scoped
+}
+
+func (s *Storage) record(action, key, contentHash string, err error) {
+	actor := ActorFrom(s.ctx)
+	e := Event{
+		Actor:       actor.Principal,
+		SourceIP:    actor.SourceIP,
+		RequestID:   actor.RequestID,
+		Action:      action,
+		Target:      key,
+		Outcome:     OutcomeSuccess,
+		ContentHash: contentHash,
+	}
+	if err != nil {
+		e.Outcome = OutcomeFailure
+		e.Error = err.Error()
+	}
+	// The operation has already happened, so a failure to audit it is
+	// reported rather than returned.
+	if err := s.log.Record(e); err != nil {
+		s.logger.LogAttrs(s.ctx, slog.LevelError, "could not record audit event",
+			slog.String("action", action),
+			slog.String("key", key),
+			slog.String("err", err.Error()),
+		)
+	}
+}
+
+func (s *Storage) Put(key string, data io.Reader) error {
+	hash := sha256.New()
+	err := s.Storage.Put(key, io.TeeReader(data, hash))
+	s.record(ActionPut, key, hex.EncodeToString(hash.Sum(nil)), err)
+	return err
+}
+
+func (s *Storage) PutIf(key string, data io.Reader, etag string) error {
+	hash := sha256.New()
+	err := storage.PutIf(s.Storage, key, io.TeeReader(data, hash), etag)
+	s.record(ActionPut, key, hex.EncodeToString(hash.Sum(nil)), err)
+	return err
+}
+
+// GetRangeOf forwards the metadata the caller already has to the backend.
+func (s *Storage) GetRangeOf(key string, info *storage.ObjectInfo, offset, length int64) (io.ReadCloser, error) {
+	return storage.GetRangeOf(s.Storage, key, info, offset, length)
+}
+
+func (s *Storage) Delete(key string) error {
+	err := s.Storage.Delete(key)
+	s.record(ActionDelete, key, "", err)
+	return err
+}
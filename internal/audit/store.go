@@ -0,0 +1,239 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"miso/internal/storage"
+)
+
+const (
+	bucketPrefix = "audit/"
+	headKey      = bucketPrefix + "head.json"
+	dayLayout    = "2006/01/02"
+)
+
+// BucketStore keeps every event in its own object under audit/, named after
+// its day and sequence number so that no object is ever overwritten. The
+// head object holds a copy of the last event, so that finding it takes one
+// read. Writers append by moving the head with a conditional write, which
+// lets the server and the admin CLI share a trail.
+type BucketStore struct {
+	Storage storage.Storage
+}
+
+func NewBucketStore(storage storage.Storage) *BucketStore {
+	return &BucketStore{Storage: storage}
+}
+
+func eventKey(e Event) string {
+	return fmt.Sprintf("%s%s/%020d.json", bucketPrefix, e.Time.UTC().Format(dayLayout), e.Seq)
+}
+
+// keySeq returns the sequence number in an event key.
+func keySeq(key string) (uint64, bool) {
+	seq, err := strconv.ParseUint(strings.TrimSuffix(path.Base(key), ".json"), 10, 64)
+	return seq, err == nil
+}
+
+// Append claims the sequence number of e by moving the head to it, then
+// writes the event. A failure in between leaves a gap in the chain, which
+// Verify reports.
+func (s *BucketStore) Append(e Event) error {
+	last, etag, err := s.head()
+	if err != nil {
+		return err
+	}
+	if !follows(e, last) {
+		return ErrConflict
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	err = storage.PutIf(s.Storage, headKey, bytes.NewReader(data), etag)
+	if errors.Is(err, storage.ErrPreconditionFailed) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	return storage.PutIf(s.Storage, eventKey(e), bytes.NewReader(data), "")
+}
+
+func (s *BucketStore) Last() (*Event, error) {
+	last, _, err := s.head()
+	return last, err
+}
+
+// head returns the last event and the ETag of the head object. Trails
+// written before the head object existed are scanned instead.
+func (s *BucketStore) head() (*Event, string, error) {
+	info, err := s.Storage.Stat(headKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if info == nil {
+		last, err := s.scanLast()
+		return last, "", err
+	}
+	last, err := s.get(headKey)
+	return last, info.ETag, err
+}
+
+// scanLast finds the last event by listing every event.
+func (s *BucketStore) scanLast() (*Event, error) {
+	keys, err := s.Storage.List(bucketPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var last string
+	var lastSeq uint64
+	for _, key := range keys {
+		if seq, ok := keySeq(key); ok && (last == "" || seq > lastSeq) {
+			last, lastSeq = key, seq
+		}
+	}
+	if last == "" {
+		return nil, nil
+	}
+	return s.get(last)
+}
+
+func (s *BucketStore) get(key string) (*Event, error) {
+	data, err := s.Storage.GetBuffer(key)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("audit event %s disappeared", key)
+	}
+	var e Event
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("audit event %s: %w", key, err)
+	}
+	return &e, nil
+}
+
+func (s *BucketStore) Read(from, to time.Time) ([]Event, error) {
+	keys, err := s.Storage.List(bucketPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the days outside the range without reading their events.
+	var fromDay, toDay string
+	if !from.IsZero() {
+		fromDay = from.UTC().Format(dayLayout)
+	}
+	if !to.IsZero() {
+		toDay = to.UTC().Format(dayLayout)
+	}
+
+	var events []Event
+	for _, key := range keys {
+		if _, ok := keySeq(key); !ok {
+			continue
+		}
+		day := path.Dir(strings.TrimPrefix(key, bucketPrefix))
+		if (fromDay != "" && day < fromDay) || (toDay != "" && day > toDay) {
+			continue
+		}
+		e, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+		if inRange(e.Time, from, to) {
+			events = append(events, *e)
+		}
+	}
+	sortBySeq(events)
+
+	return events, nil
+}
+
+// FileStore keeps events as JSON lines in a local file that is only ever
+// appended to. Unlike BucketStore, it must have a single writer.
+type FileStore struct {
+	Path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (s *FileStore) Append(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// scan calls fn for every event in the file.
+func (s *FileStore) scan(fn func(Event)) error {
+	f, err := os.Open(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s:%d: %w", s.Path, line, err)
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+func (s *FileStore) Last() (*Event, error) {
+	var last *Event
+	err := s.scan(func(e Event) {
+		last = &e
+	})
+	return last, err
+}
+
+func (s *FileStore) Read(from, to time.Time) ([]Event, error) {
+	var events []Event
+	err := s.scan(func(e Event) {
+		if inRange(e.Time, from, to) {
+			events = append(events, e)
+		}
+	})
+	sortBySeq(events)
+	return events, err
+}
@@ -0,0 +1,231 @@
+// Package certs serves TLS with certificates that are read again when they
+// rotate, and maps client certificates to principals.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"miso/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var reloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "miso_tls_reloads_total",
+	Help: "Certificate reloads after the files changed, by result: applied or invalid.",
+}, []string{"result"})
+
+const (
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// defaultCheckInterval is how often handshakes look at the files unless
+// CheckInterval is changed.
+const defaultCheckInterval = 10 * time.Second
+
+// Reloader holds the server certificate and the client CAs, and reads the
+// files again when a handshake finds that their modification time changed.
+type Reloader struct {
+	// CheckInterval bounds how often handshakes look at the modification
+	// times of the files. Zero checks on every handshake. Set it before
+	// serving.
+	CheckInterval time.Duration
+
+	cfg    config.TLS
+	logger *slog.Logger
+
+	loaded  atomic.Pointer[loaded]
+	checked atomic.Int64
+
+	// mu serializes reloads. Handshakes only take it when the files
+	// changed.
+	mu sync.Mutex
+}
+
+// loaded is the result of reading the files.
+type loaded struct {
+	modTimes  []time.Time
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewReloader loads the files named by cfg.
+func NewReloader(cfg config.TLS, logger *slog.Logger) (*Reloader, error) {
+	r := &Reloader{CheckInterval: defaultCheckInterval, cfg: cfg, logger: logger}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	r.checked.Store(time.Now().UnixNano())
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCA != "" {
+		files = append(files, r.cfg.ClientCA)
+	}
+	return files
+}
+
+func (r *Reloader) stat() ([]time.Time, error) {
+	var modTimes []time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	return modTimes, nil
+}
+
+// Reload reads the files. The previous certificates stay in use when they
+// are invalid.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reload()
+}
+
+func (r *Reloader) reload() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	var clientCAs *x509.CertPool
+	if r.cfg.ClientCA != "" {
+		data, err := os.ReadFile(r.cfg.ClientCA)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%s: no PEM certificates", r.cfg.ClientCA)
+		}
+	}
+
+	r.loaded.Store(&loaded{modTimes: modTimes, cert: &cert, clientCAs: clientCAs})
+	return nil
+}
+
+// current returns the certificates, first reloading them when the files
+// changed. The files are looked at once per CheckInterval, by a single
+// handshake. While the new files are invalid, e.g. because only the
+// certificate was replaced yet, the previous ones stay in use.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	now := time.Now().UnixNano()
+	last := r.checked.Load()
+	if now-last >= int64(r.CheckInterval) && r.checked.CompareAndSwap(last, now) {
+		r.check()
+	}
+	l := r.loaded.Load()
+	return l.cert, l.clientCAs
+}
+
+// check reloads the files when their modification times changed.
+func (r *Reloader) check() {
+	modTimes, err := r.stat()
+	if err != nil || slices.Equal(modTimes, r.loaded.Load().modTimes) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Another handshake may have reloaded the files meanwhile.
+	if slices.Equal(modTimes, r.loaded.Load().modTimes) {
+		return
+	}
+	if err := r.reload(); err != nil {
+		reloadsTotal.WithLabelValues("invalid").Inc()
+		r.logger.Error("could not reload certificates", slog.String("err", err.Error()))
+	} else {
+		reloadsTotal.WithLabelValues("applied").Inc()
+		r.logger.Info("certificates reloaded")
+	}
+}
+
+// ServerConfig returns the TLS configuration of a server. Client
+// certificates are only asked for when clientAuth is set.
+func (r *Reloader) ServerConfig(clientAuth bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := r.current()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if clientAuth {
+				cfg.ClientCAs = clientCAs
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				if r.cfg.ClientAuth == ClientAuthRequire {
+					cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Principal names the owner of a client certificate: cert: followed by
+// the common name, or by the whole subject when it has no common name.
+func Principal(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return "cert:" + cert.Subject.CommonName
+	}
+	return "cert:" + cert.Subject.String()
+}
+
+// ClientPrincipal returns the principal of the verified client certificate
+// of the request, or "" when the client sent none.
+func ClientPrincipal(c echo.Context) string {
+	state := c.Request().TLS
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+	return Principal(state.VerifiedChains[0][0])
+}
+
+var clientAuthModes = []string{"", ClientAuthOptional, ClientAuthRequire}
+
+// Validate checks the TLS settings.
+func Validate(cfg config.TLS) error {
+	var errs []error
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	if !slices.Contains(clientAuthModes, cfg.ClientAuth) {
+		errs = append(errs, fmt.Errorf("tls.client_auth: must be %s or %s", ClientAuthOptional, ClientAuthRequire))
+	}
+	if cfg.ClientAuth != "" {
+		if cfg.CertFile == "" {
+			errs = append(errs, errors.New("tls.client_auth: requires tls.cert_file and tls.key_file"))
+		}
+		if cfg.ClientCA == "" {
+			errs = append(errs, errors.New("tls.client_ca: required with tls.client_auth"))
+		}
+	}
+	if len(cfg.AdminPrincipals) > 0 && cfg.ClientAuth == "" {
+		errs = append(errs, errors.New("tls.admin_principals: requires tls.client_auth"))
+	}
+
+	return errors.Join(errs...)
+}
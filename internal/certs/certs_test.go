@@ -0,0 +1,224 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"miso/internal/certs"
+	"miso/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var serials int64
+
+// issue creates a certificate for name, signed by parent or else
+// self-signed as a CA.
+func issue(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serials++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serials),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, any(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// write saves cert and its key as PEM files named after name in dir.
+func write(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return certFile, keyFile
+}
+
+type fixture struct {
+	ca     tls.Certificate
+	cfg    config.TLS
+	server *httptest.Server
+}
+
+// newServer serves the principal of each request over mTLS, looking for
+// rotated files every checkInterval.
+func newServer(t *testing.T, clientAuth string, checkInterval time.Duration) *fixture {
+	t.Helper()
+	dir := t.TempDir()
+	ca := issue(t, "miso-ca", nil)
+	caFile, _ := write(t, dir, "ca", ca)
+	certFile, keyFile := write(t, dir, "server", issue(t, "miso", &ca))
+	cfg := config.TLS{CertFile: certFile, KeyFile: keyFile, ClientAuth: clientAuth, ClientCA: caFile}
+
+	reloader, err := certs.NewReloader(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	reloader.CheckInterval = checkInterval
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, certs.ClientPrincipal(c))
+	})
+	server := httptest.NewUnstartedServer(e)
+	server.TLS = reloader.ServerConfig(true)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return &fixture{ca: ca, cfg: cfg, server: server}
+}
+
+// get calls the server, presenting client when it is set, and returns the
+// principal and the serial number of the server certificate.
+func (f *fixture) get(t *testing.T, client *tls.Certificate) (string, int64, error) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(f.ca.Leaf)
+	tlsConfig := &tls.Config{RootCAs: roots}
+	if client != nil {
+		tlsConfig.Certificates = []tls.Certificate{*client}
+	}
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+
+	res, err := httpClient.Get(f.server.URL)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body), res.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestClientAuth(t *testing.T) {
+	t.Run("optional", func(t *testing.T) {
+		f := newServer(t, certs.ClientAuthOptional, time.Hour)
+		agent := issue(t, "build-agent", &f.ca)
+
+		principal, _, err := f.get(t, &agent)
+		require.NoError(t, err)
+		assert.Equal(t, "cert:build-agent", principal)
+
+		principal, _, err = f.get(t, nil)
+		require.NoError(t, err)
+		assert.Empty(t, principal)
+	})
+
+	t.Run("require", func(t *testing.T) {
+		f := newServer(t, certs.ClientAuthRequire, time.Hour)
+		agent := issue(t, "build-agent", &f.ca)
+
+		principal, _, err := f.get(t, &agent)
+		require.NoError(t, err)
+		assert.Equal(t, "cert:build-agent", principal)
+
+		_, _, err = f.get(t, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		f := newServer(t, certs.ClientAuthOptional, time.Hour)
+		other := issue(t, "other-ca", nil)
+		agent := issue(t, "build-agent", &other)
+
+		// The client only sends certificates issued by the CAs the server
+		// asks for, so the request is anonymous.
+		principal, _, err := f.get(t, &agent)
+		require.NoError(t, err)
+		assert.Empty(t, principal)
+	})
+}
+
+func TestRotation(t *testing.T) {
+	f := newServer(t, certs.ClientAuthOptional, 0)
+	_, before, err := f.get(t, nil)
+	require.NoError(t, err)
+
+	// A certificate without its new key is not picked up yet.
+	rotated := issue(t, "miso", &f.ca)
+	dir := t.TempDir()
+	certFile, keyFile := write(t, dir, "server", rotated)
+	data, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(f.cfg.CertFile, data, 0o600))
+
+	_, serial, err := f.get(t, nil)
+	require.NoError(t, err)
+	assert.Equal(t, before, serial)
+
+	data, err = os.ReadFile(keyFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(f.cfg.KeyFile, data, 0o600))
+
+	_, serial, err = f.get(t, nil)
+	require.NoError(t, err)
+	assert.Equal(t, rotated.Leaf.SerialNumber.Int64(), serial)
+}
+
+func TestRotationCheckInterval(t *testing.T) {
+	f := newServer(t, certs.ClientAuthOptional, time.Hour)
+	_, before, err := f.get(t, nil)
+	require.NoError(t, err)
+
+	// The files are not looked at again before the interval passed.
+	write(t, filepath.Dir(f.cfg.CertFile), "server", issue(t, "miso", &f.ca))
+	_, serial, err := f.get(t, nil)
+	require.NoError(t, err)
+	assert.Equal(t, before, serial)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, certs.Validate(config.TLS{}))
+	assert.NoError(t, certs.Validate(config.TLS{
+		CertFile: "tls.crt", KeyFile: "tls.key", ClientAuth: certs.ClientAuthRequire, ClientCA: "ca.crt",
+		AdminPrincipals: []string{"cert:build-agent"},
+	}))
+
+	err := certs.Validate(config.TLS{CertFile: "tls.crt", ClientAuth: "always", AdminPrincipals: []string{"cert:build-agent"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "tls: cert_file and key_file must be set together")
+		assert.Contains(t, err.Error(), "tls.client_auth: must be optional or require")
+		assert.Contains(t, err.Error(), "tls.client_ca: required")
+	}
+
+	err = certs.Validate(config.TLS{AdminPrincipals: []string{"cert:build-agent"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "tls.admin_principals: requires tls.client_auth")
+	}
+}
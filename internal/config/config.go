@@ -1,29 +1,241 @@
 package config
 
 import (
+	"net/http"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	App     App     `mapstructure:"app"`
-	Metrics Metrics `mapstructure:"metrics"`
-	S3      S3      `mapstructure:"s3"`
+	App       App       `mapstructure:"app"`
+	Metrics   Metrics   `mapstructure:"metrics"`
+	S3        S3        `mapstructure:"s3"`
+	Cache     Cache     `mapstructure:"cache"`
+	CDN       CDN       `mapstructure:"cdn"`
+	Tracing   Tracing   `mapstructure:"tracing"`
+	Stats     Stats     `mapstructure:"stats"`
+	Audit     Audit     `mapstructure:"audit"`
+	Webhooks  Webhooks  `mapstructure:"webhooks"`
+	Health    Health    `mapstructure:"health"`
+	RateLimit RateLimit `mapstructure:"rate_limit"`
+	CORS      CORS      `mapstructure:"cors"`
+	Security  Security  `mapstructure:"security"`
+	TLS       TLS       `mapstructure:"tls"`
 }
 
 type App struct {
 	Host     string `mapstructure:"host"`
 	Port     string `mapstructure:"port"`
-	Secret   string `mapstructure:"secret"`
-	LogLevel string `mapstructure:"loglevel"`
+	Secret   string `mapstructure:"secret" secret:"true" reload:"true"`
+	LogLevel string `mapstructure:"loglevel" reload:"true"`
+	// On SIGTERM or interrupt, readiness fails for DrainPeriod before the
+	// servers stop accepting connections, and in-flight requests, such as
+	// proxied downloads, get ShutdownTimeout to finish.
+	DrainPeriod     time.Duration `mapstructure:"drain_period" reload:"true"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout" reload:"true"`
+	// MaxUploadSizeMB caps the request body of the admin routes. Module
+	// archives are held in memory while they are inspected.
+	MaxUploadSizeMB int64 `mapstructure:"max_upload_size_mb"`
 }
 
 type Metrics struct {
 	Port string `mapstructure:"port"`
 }
 
+// DefaultPresignExpiry is the lifetime of download URLs when
+// s3.presign_expiry is not set.
+const DefaultPresignExpiry = 10 * time.Minute
+
 type S3 struct {
-	Bucket       string `mapstructure:"bucket"`
-	DownloadMode string `mapstructure:"download_mode"`
+	Bucket         string         `mapstructure:"bucket"`
+	DownloadMode   string         `mapstructure:"download_mode" reload:"true"`
+	DownloadPolicy DownloadPolicy `mapstructure:"download_policy" reload:"true"`
+	// PresignExpiry is how long presigned and CDN signed URLs stay valid.
+	PresignExpiry time.Duration `mapstructure:"presign_expiry" reload:"true"`
+
+	// Endpoint points the client at an S3-compatible service such as MinIO
+	// or Ceph. Most of them need ForcePathStyle as well.
+	Endpoint       string `mapstructure:"endpoint"`
+	ForcePathStyle bool   `mapstructure:"force_path_style"`
+	Region         string `mapstructure:"region"`
+	// Static credentials replace the default AWS credential chain.
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key" secret:"true"`
+	SessionToken    string `mapstructure:"session_token" secret:"true"`
+	// AssumeRoleARN is assumed through STS with the credentials above.
+	AssumeRoleARN string `mapstructure:"assume_role_arn"`
+	// CABundle is the path to a PEM file of additional certificate
+	// authorities trusted for the endpoint.
+	CABundle string `mapstructure:"ca_bundle"`
+}
+
+// DownloadPolicy overrides DownloadMode per request. Namespace overrides
+// win over rules, and the first matching rule wins over the default mode.
+type DownloadPolicy struct {
+	Namespaces map[string]string `mapstructure:"namespaces"`
+	Rules      []DownloadRule    `mapstructure:"rules"`
+}
+
+// DownloadRule matches requests on every condition that is set.
+type DownloadRule struct {
+	Mode string `mapstructure:"mode" yaml:"mode"`
+	// CIDRs matches clients whose address is in one of the ranges.
+	CIDRs []string `mapstructure:"cidrs" yaml:"cidrs,omitempty"`
+	// MaxSizeMB matches objects no larger than the given size.
+	MaxSizeMB int64 `mapstructure:"max_size_mb" yaml:"max_size_mb,omitempty"`
+}
+
+// Cache configures the local disk cache used for proxied downloads and the
+// in-process cache of version listings.
+type Cache struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Dir       string        `mapstructure:"dir"`
+	MaxSizeMB int64         `mapstructure:"max_size_mb"`
+	ListTTL   time.Duration `mapstructure:"list_ttl"`
+}
+
+// CDN rewrites download URLs onto a custom domain, such as a CloudFront
+// distribution in front of the bucket. Downloads are signed with the
+// CloudFront key pair when one is configured.
+type CDN struct {
+	// Domain is the base URL of the distribution, e.g.
+	// "https://downloads.example.com". Downloads use S3 presigned URLs
+	// when it is empty.
+	Domain string `mapstructure:"domain"`
+	// KeyPairID is the CloudFront public key ID. PrivateKey holds the
+	// PEM encoded RSA key, or PrivateKeyFile the path to it.
+	KeyPairID      string `mapstructure:"key_pair_id"`
+	PrivateKey     string `mapstructure:"private_key" secret:"true"`
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+}
+
+// Tracing configures the export of OpenTelemetry traces.
+type Tracing struct {
+	// Exporter is "otlp-grpc", "otlp-http" or "stdout". Tracing is off
+	// when it is empty.
+	Exporter string `mapstructure:"exporter"`
+	// Endpoint is the collector address, e.g. "collector:4317". The
+	// standard OTEL_EXPORTER_OTLP_* variables apply when it is empty.
+	Endpoint string `mapstructure:"endpoint"`
+	Insecure bool   `mapstructure:"insecure"`
+	// SampleRatio is the share of new traces that are recorded. Requests
+	// with a sampled parent are always recorded.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+	ServiceName string  `mapstructure:"service_name"`
+}
+
+// Stats configures the recording of download events in the bucket.
+type Stats struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Events are written when BatchSize of them are pending, or every
+	// FlushInterval.
+	BatchSize     int           `mapstructure:"batch_size"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// Audit configures the audit trail of write and admin operations.
+type Audit struct {
+	Enabled bool `mapstructure:"enabled"`
+	// File is a local JSON lines file to write events to. Events are kept
+	// in the bucket under audit/ when it is empty.
+	File string `mapstructure:"file"`
+}
+
+// Webhooks configures the notifications sent when the registry changes.
+type Webhooks struct {
+	Hooks []Webhook `mapstructure:"hooks" reload:"true"`
+	// A delivery is tried MaxAttempts times, waiting Backoff before the
+	// first retry and twice as long before each further one. Deliveries
+	// that still fail are kept under webhooks/dead-letter/ in the bucket.
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	Backoff     time.Duration `mapstructure:"backoff"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+// Webhook receives the events it subscribes to, signed with Secret.
+type Webhook struct {
+	URL string `mapstructure:"url" yaml:"url"`
+	// Namespace limits the webhook to the events of one namespace.
+	Namespace string `mapstructure:"namespace" yaml:"namespace,omitempty"`
+	// Events limits the webhook to the given event types.
+	Events []string `mapstructure:"events" yaml:"events,omitempty"`
+	Secret string   `mapstructure:"secret" yaml:"secret" secret:"true"`
+}
+
+// Health configures the readiness checks of the health server.
+type Health struct {
+	// CheckTimeout bounds every readiness check.
+	CheckTimeout time.Duration `mapstructure:"check_timeout"`
+	// IndexMaxAge fails readiness when the registry index was last written
+	// longer ago. The index age is not checked when it is zero.
+	IndexMaxAge time.Duration `mapstructure:"index_max_age" reload:"true"`
+}
+
+// RateLimit configures the request limits per client, told apart by their
+// principal or, for anonymous requests, their address.
+type RateLimit struct {
+	List     Limit `mapstructure:"list"`
+	Download Limit `mapstructure:"download"`
+	Publish  Limit `mapstructure:"publish"`
+	// MaxProxyStreams caps the proxied downloads streaming at once across
+	// all clients. There is no cap when it is zero.
+	MaxProxyStreams int64 `mapstructure:"max_proxy_streams"`
+}
+
+// Limit allows Rate requests per second with bursts of up to Burst. There
+// is no limit when Rate is zero.
+type Limit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// CORS configures which web origins may call the registry API. Without
+// CORS, browsers only allow requests from the registry's own origin.
+type CORS struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Read applies to the registry protocol routes, Admin to /v1/admin.
+	Read  CORSPolicy `mapstructure:"read"`
+	Admin CORSPolicy `mapstructure:"admin"`
+}
+
+// CORSPolicy lists what cross-origin requests may do. No origin may make
+// cross-origin requests when AllowOrigins is empty.
+type CORSPolicy struct {
+	AllowOrigins []string `mapstructure:"allow_origins"`
+	AllowMethods []string `mapstructure:"allow_methods"`
+	AllowHeaders []string `mapstructure:"allow_headers"`
+}
+
+// Security configures the security headers of the registry API.
+type Security struct {
+	// HSTSMaxAge is sent in Strict-Transport-Security with responses to
+	// HTTPS requests, including those a proxy marks with X-Forwarded-Proto.
+	// The header is not sent when it is zero.
+	HSTSMaxAge int `mapstructure:"hsts_max_age"`
+	// ContentSecurityPolicy is sent with HTML responses.
+	ContentSecurityPolicy string `mapstructure:"content_security_policy"`
+	// TrustedProxies lists the CIDRs of the proxies whose X-Forwarded-For
+	// header gives the client address. Without any, the address of the
+	// connection is used.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// TLS serves both servers over HTTPS when CertFile and KeyFile are set.
+// The files are read again when they change, so certificates can rotate
+// without a restart.
+type TLS struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ClientAuth asks clients of the main server for a certificate signed
+	// by ClientCA: "optional" verifies it when one is sent, "require"
+	// rejects connections without one. The certificate subject becomes
+	// the principal of the request.
+	ClientAuth string `mapstructure:"client_auth"`
+	ClientCA   string `mapstructure:"client_ca"`
+	// AdminPrincipals may call the admin routes without the secret, e.g.
+	// cert:build-agent for a certificate with that common name.
+	AdminPrincipals []string `mapstructure:"admin_principals" reload:"true"`
 }
 
 func LoadConfig(paths ...string) (*Config, error) {
@@ -37,13 +249,35 @@ func LoadConfig(paths ...string) (*Config, error) {
 	viper.AddConfigPath("./config")
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
+	viper.SetDefault("app.shutdown_timeout", 10*time.Second)
+	viper.SetDefault("app.max_upload_size_mb", 512)
+	viper.SetDefault("s3.presign_expiry", DefaultPresignExpiry)
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+	viper.SetDefault("tracing.service_name", "miso")
+	viper.SetDefault("webhooks.max_attempts", 5)
+	viper.SetDefault("webhooks.backoff", time.Second)
+	viper.SetDefault("webhooks.timeout", 10*time.Second)
+	viper.SetDefault("health.check_timeout", 5*time.Second)
+	viper.SetDefault("cors.enabled", true)
+	viper.SetDefault("cors.read.allow_origins", []string{"*"})
+	viper.SetDefault("cors.read.allow_methods", []string{http.MethodGet, http.MethodHead})
+	viper.SetDefault("cors.read.allow_headers", []string{"Origin", "Content-Type", "Accept", "Authorization"})
+	viper.SetDefault("cors.admin.allow_methods", []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete})
+	viper.SetDefault("cors.admin.allow_headers", []string{"Origin", "Content-Type", "Accept", "Authorization"})
+	viper.SetDefault("security.hsts_max_age", 31536000)
+	viper.SetDefault("security.content_security_policy", "default-src 'none'; img-src https: data:; style-src 'unsafe-inline'")
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
 	}
-	if err := viper.BindEnv("app.secret", "APP_SECRET"); err != nil {
+	if err := bindEnv(); err != nil {
 		return nil, err
 	}
+
+	return unmarshal()
+}
+
+func unmarshal() (*Config, error) {
 	var config Config
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, err
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// envPrefix is prepended to the upper-cased key of every setting, e.g.
+// MISO_S3_BUCKET for s3.bucket.
+const envPrefix = "MISO"
+
+// redacted replaces the value of secret settings in Redacted.
+const redacted = "REDACTED"
+
+// setting is a leaf field of Config, addressed by its dotted key.
+type setting struct {
+	key    string
+	index  []int
+	field  reflect.StructField
+	secret bool
+	reload bool
+}
+
+// settings lists the fields of t recursively. Fields tagged secret:"true"
+// can be read from files and are redacted when printed. Fields tagged
+// reload:"true", or nested in such a field, can change at runtime.
+func settings(t reflect.Type) []setting {
+	return walk(t, "", nil, false)
+}
+
+func walk(t reflect.Type, prefix string, parent []int, reload bool) []setting {
+	var out []setting
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := prefix + f.Tag.Get("mapstructure")
+		index := append(slices.Clone(parent), i)
+		fieldReload := reload || f.Tag.Get("reload") == "true"
+		if f.Type.Kind() == reflect.Struct {
+			out = append(out, walk(f.Type, key+".", index, fieldReload)...)
+			continue
+		}
+		out = append(out, setting{
+			key:    key,
+			index:  index,
+			field:  f,
+			secret: f.Tag.Get("secret") == "true",
+			reload: fieldReload,
+		})
+	}
+	return out
+}
+
+// overridable reports whether the setting is a plain value that can be set
+// from a single environment variable or flag. Maps and lists of rules can
+// only be set in the config file.
+func (s setting) overridable() bool {
+	switch s.field.Type.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return s.field.Type.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// EnvName returns the environment variable that overrides key.
+func EnvName(key string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ReloadableKeys returns the keys of the settings that can change while
+// serving.
+func ReloadableKeys() []string {
+	var keys []string
+	for _, s := range settings(reflect.TypeOf(Config{})) {
+		if s.reload {
+			keys = append(keys, s.key)
+		}
+	}
+	return keys
+}
+
+var boundFlags *pflag.FlagSet
+
+// BindFlags adds a flag named after the key of every overridable setting to
+// fs, e.g. --s3.bucket. Flags take precedence over the environment and the
+// config file once LoadConfig runs.
+func BindFlags(fs *pflag.FlagSet) error {
+	for _, s := range settings(reflect.TypeOf(Config{})) {
+		if !s.overridable() {
+			continue
+		}
+		usage := fmt.Sprintf("overrides %s (env %s)", s.key, EnvName(s.key))
+		switch {
+		case s.field.Type == reflect.TypeOf(time.Duration(0)):
+			fs.Duration(s.key, 0, usage)
+		case s.field.Type.Kind() == reflect.String:
+			fs.String(s.key, "", usage)
+		case s.field.Type.Kind() == reflect.Bool:
+			fs.Bool(s.key, false, usage)
+		case s.field.Type.Kind() == reflect.Slice:
+			fs.StringSlice(s.key, nil, usage)
+		case s.field.Type.Kind() == reflect.Float64:
+			fs.Float64(s.key, 0, usage)
+		default:
+			fs.Int64(s.key, 0, usage)
+		}
+		if err := viper.BindPFlag(s.key, fs.Lookup(s.key)); err != nil {
+			return err
+		}
+	}
+	boundFlags = fs
+	return nil
+}
+
+// bindEnv maps every overridable setting to its MISO_* variable. Secrets are
+// also read from the file named by the variable with a _FILE suffix, unless
+// a flag sets them.
+func bindEnv() error {
+	for _, s := range settings(reflect.TypeOf(Config{})) {
+		if !s.overridable() {
+			continue
+		}
+		if err := viper.BindEnv(s.key, EnvName(s.key)); err != nil {
+			return err
+		}
+	}
+
+	files, err := secretFiles()
+	if err != nil {
+		return err
+	}
+	for key, path := range files {
+		value, err := readSecretFile(key, path)
+		if err != nil {
+			return err
+		}
+		viper.Set(key, value)
+	}
+
+	// APP_SECRET predates the MISO_ prefix.
+	return viper.BindEnv("app.secret", EnvName("app.secret"), "APP_SECRET")
+}
+
+// secretFiles returns the files named by the _FILE variables of secret
+// settings, by key. Settings set by a flag are left out.
+func secretFiles() (map[string]string, error) {
+	files := make(map[string]string)
+	for _, s := range settings(reflect.TypeOf(Config{})) {
+		if !s.secret || !s.overridable() {
+			continue
+		}
+		env := EnvName(s.key)
+		path, ok := os.LookupEnv(env + "_FILE")
+		if !ok || flagChanged(s.key) {
+			continue
+		}
+		if _, ok := os.LookupEnv(env); ok {
+			return nil, fmt.Errorf("%s and %s_FILE are both set", env, env)
+		}
+		files[s.key] = path
+	}
+	return files, nil
+}
+
+// readSecretFile returns the content of the file holding the secret key,
+// without its trailing newline.
+func readSecretFile(key, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %w", EnvName(key), err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func flagChanged(key string) bool {
+	if boundFlags == nil {
+		return false
+	}
+	f := boundFlags.Lookup(key)
+	return f != nil && f.Changed
+}
+
+// Redacted returns the settings of c as nested maps keyed like the config
+// file, with every secret that is set replaced by "REDACTED".
+func (c *Config) Redacted() map[string]interface{} {
+	out := make(map[string]interface{})
+	v := reflect.ValueOf(c).Elem()
+	for _, s := range settings(v.Type()) {
+		field := v.FieldByIndex(s.index)
+		value := field.Interface()
+		if s.secret && value != "" {
+			value = redacted
+		}
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct {
+			value = redactElems(field)
+		}
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+
+		m := out
+		parts := strings.Split(s.key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			if _, ok := m[part]; !ok {
+				m[part] = make(map[string]interface{})
+			}
+			m = m[part].(map[string]interface{})
+		}
+		m[parts[len(parts)-1]] = value
+	}
+	return out
+}
+
+// redactElems returns a copy of a slice of structs with the secret fields of
+// every element that are set replaced by "REDACTED".
+func redactElems(v reflect.Value) interface{} {
+	out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(out, v)
+	for i := 0; i < out.Len(); i++ {
+		elem := out.Index(i)
+		for j := 0; j < elem.NumField(); j++ {
+			f := elem.Field(j)
+			if elem.Type().Field(j).Tag.Get("secret") == "true" && f.Kind() == reflect.String && f.String() != "" {
+				f.SetString(redacted)
+			}
+		}
+	}
+	return out.Interface()
+}
@@ -0,0 +1,99 @@
+package config_test
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"miso/internal/config"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	data := "app:\n  port: 9000\n  secret: from-file\ns3:\n  bucket: miso\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(data), 0o600))
+	t.Cleanup(viper.Reset)
+	return dir
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	dir := writeConfig(t)
+	t.Setenv("MISO_S3_BUCKET", "from-env")
+	t.Setenv("MISO_S3_PRESIGN_EXPIRY", "1h")
+	t.Setenv("MISO_CACHE_ENABLED", "true")
+
+	fs := pflag.NewFlagSet("miso", pflag.ContinueOnError)
+	require.NoError(t, config.BindFlags(fs))
+	require.NoError(t, fs.Parse([]string{"--app.port", "9100", "--s3.bucket", "from-flag"}))
+
+	cfg, err := config.LoadConfig(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "9100", cfg.App.Port)
+	assert.Equal(t, "from-flag", cfg.S3.Bucket)
+	assert.Equal(t, time.Hour, cfg.S3.PresignExpiry)
+	assert.True(t, cfg.Cache.Enabled)
+	assert.Equal(t, "from-file", cfg.App.Secret)
+}
+
+func TestLoadConfigSecretFile(t *testing.T) {
+	dir := writeConfig(t)
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("from-secret-file\n"), 0o600))
+	t.Setenv("MISO_APP_SECRET_FILE", secret)
+
+	cfg, err := config.LoadConfig(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "from-secret-file", cfg.App.Secret)
+
+	t.Setenv("MISO_APP_SECRET", "from-env")
+	_, err = config.LoadConfig(dir)
+	assert.ErrorContains(t, err, "MISO_APP_SECRET and MISO_APP_SECRET_FILE are both set")
+}
+
+func TestReloadSecretFile(t *testing.T) {
+	dir := writeConfig(t)
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("before\n"), 0o600))
+	t.Setenv("MISO_APP_SECRET_FILE", secret)
+
+	cfg, err := config.LoadConfig(dir)
+	require.NoError(t, err)
+	r := config.NewReloader(cfg, func(*config.Config) error { return nil }, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r.Watch()
+
+	require.NoError(t, os.WriteFile(secret, []byte("after\n"), 0o600))
+	assert.Eventually(t, func() bool {
+		return r.Current().App.Secret == "after"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := config.Config{
+		App: config.App{Port: "9000", Secret: "hunter2"},
+		S3:  config.S3{Bucket: "miso", PresignExpiry: time.Minute},
+		Webhooks: config.Webhooks{Hooks: []config.Webhook{
+			{URL: "https://hooks.example.com", Secret: "hook-secret"},
+		}},
+	}
+
+	settings := cfg.Redacted()
+	app := settings["app"].(map[string]interface{})
+	s3 := settings["s3"].(map[string]interface{})
+	assert.Equal(t, "REDACTED", app["secret"])
+	assert.Equal(t, "9000", app["port"])
+	assert.Equal(t, "", s3["secret_access_key"])
+	assert.Equal(t, "1m0s", s3["presign_expiry"])
+
+	hooks := settings["webhooks"].(map[string]interface{})["hooks"].([]config.Webhook)
+	assert.Equal(t, "REDACTED", hooks[0].Secret)
+	assert.Equal(t, "hook-secret", cfg.Webhooks.Hooks[0].Secret)
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/spf13/viper"
+)
+
+var reloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "miso_config_reloads_total",
+	Help: "Config file reloads by result: applied, unchanged, invalid or rejected.",
+}, []string{"result"})
+
+// ErrRestartRequired is returned by Reload when a setting that is only read
+// at startup has changed.
+var ErrRestartRequired = errors.New("changed settings require a restart")
+
+// Reloader holds the current configuration and applies changes to the
+// settings tagged reload:"true" at runtime.
+type Reloader struct {
+	current  atomic.Pointer[Config]
+	validate func(*Config) error
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	apply []func(*Config)
+
+	// fileMu serialises the reads of the config and secret files, which
+	// change viper's global state.
+	fileMu sync.Mutex
+}
+
+// NewReloader starts from cfg. Every new configuration must pass validate
+// before it is applied.
+func NewReloader(cfg *Config, validate func(*Config) error, logger *slog.Logger) *Reloader {
+	r := &Reloader{validate: validate, logger: logger}
+	r.current.Store(cfg)
+	return r
+}
+
+// Current returns the configuration that is in effect.
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// OnReload registers fn to be called with every applied configuration.
+func (r *Reloader) OnReload(fn func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.apply = append(r.apply, fn)
+}
+
+// Reload validates next and applies it. The whole configuration is rejected
+// when it is invalid or changes a setting that needs a restart, so a reload
+// never applies part of a file.
+func (r *Reloader) Reload(next *Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.current.Load()
+	changed, restart := diff(current, next)
+	switch {
+	case len(changed) == 0 && len(restart) == 0:
+		reloadsTotal.WithLabelValues("unchanged").Inc()
+		return nil
+	case len(restart) != 0:
+		reloadsTotal.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("%w: %s", ErrRestartRequired, strings.Join(restart, ", "))
+	}
+	if err := r.validate(next); err != nil {
+		reloadsTotal.WithLabelValues("invalid").Inc()
+		return err
+	}
+
+	for _, fn := range r.apply {
+		fn(next)
+	}
+	r.current.Store(next)
+	reloadsTotal.WithLabelValues("applied").Inc()
+	r.logger.Info("configuration reloaded", slog.String("changed", strings.Join(changed, ", ")))
+
+	return nil
+}
+
+// Watch reloads the configuration whenever the config file, or a file a
+// secret is read from through a _FILE variable, changes. Errors are
+// logged, and the previous configuration stays in effect.
+func (r *Reloader) Watch() {
+	viper.OnConfigChange(func(fsnotify.Event) {
+		r.fileMu.Lock()
+		defer r.fileMu.Unlock()
+		r.reloadFile()
+	})
+	viper.WatchConfig()
+
+	if err := r.watchSecrets(); err != nil {
+		r.logger.Error("could not watch secret files", slog.String("err", err.Error()))
+	}
+}
+
+// reloadFile applies the settings viper holds. The caller must hold
+// r.fileMu.
+func (r *Reloader) reloadFile() {
+	next, err := unmarshal()
+	if err == nil {
+		err = r.Reload(next)
+	}
+	if err != nil {
+		r.logger.Error("configuration not reloaded", slog.String("err", err.Error()))
+	}
+}
+
+// watchSecrets reads the secret files again whenever their directories
+// change. Watching the directories rather than the files also catches
+// Kubernetes replacing a mounted secret through a symlink.
+func (r *Reloader) watchSecrets() error {
+	files, err := secretFiles()
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				r.fileMu.Lock()
+				if r.readSecrets(files) {
+					r.reloadFile()
+				}
+				r.fileMu.Unlock()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.logger.Error("could not watch secret files", slog.String("err", err.Error()))
+			}
+		}
+	}()
+	return nil
+}
+
+// readSecrets updates the secrets whose files changed and reports whether
+// any did. A file that is missing or empty, as it may be for a moment while
+// it is replaced, keeps the previous secret.
+func (r *Reloader) readSecrets(files map[string]string) bool {
+	changed := false
+	for key, path := range files {
+		value, err := readSecretFile(key, path)
+		if err != nil || value == "" || value == viper.GetString(key) {
+			continue
+		}
+		viper.Set(key, value)
+		changed = true
+	}
+	return changed
+}
+
+// diff returns the keys that differ between a and b, split into those that
+// can be reloaded and those that need a restart.
+func diff(a, b *Config) (changed, restart []string) {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for _, s := range settings(va.Type()) {
+		if reflect.DeepEqual(va.FieldByIndex(s.index).Interface(), vb.FieldByIndex(s.index).Interface()) {
+			continue
+		}
+		if s.reload {
+			changed = append(changed, s.key)
+		} else {
+			restart = append(restart, s.key)
+		}
+	}
+	return changed, restart
+}
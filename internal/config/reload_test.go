@@ -0,0 +1,90 @@
+package config_test
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"miso/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	initial := &config.Config{
+		App: config.App{Port: "9000", LogLevel: "info"},
+		S3:  config.S3{Bucket: "miso", DownloadMode: "presigned-url"},
+	}
+	validate := func(cfg *config.Config) error {
+		if cfg.S3.DownloadMode == "invalid" {
+			return errors.New("s3.download_mode: invalid")
+		}
+		return nil
+	}
+	r := config.NewReloader(initial, validate, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var applied []*config.Config
+	r.OnReload(func(cfg *config.Config) { applied = append(applied, cfg) })
+
+	t.Run("applies reloadable settings", func(t *testing.T) {
+		next := *initial
+		next.App.LogLevel = "debug"
+		next.S3.DownloadMode = "proxy"
+		next.S3.PresignExpiry = time.Hour
+		next.S3.DownloadPolicy.Namespaces = map[string]string{"acme": "proxy"}
+
+		require.NoError(t, r.Reload(&next))
+		assert.Same(t, &next, r.Current())
+		assert.Len(t, applied, 1)
+	})
+
+	t.Run("rejects restart-only settings", func(t *testing.T) {
+		next := *r.Current()
+		next.App.Port = "9100"
+		next.App.LogLevel = "warn"
+
+		err := r.Reload(&next)
+		assert.ErrorIs(t, err, config.ErrRestartRequired)
+		assert.ErrorContains(t, err, "app.port")
+		assert.Equal(t, "debug", r.Current().App.LogLevel)
+		assert.Len(t, applied, 1)
+	})
+
+	t.Run("rejects invalid settings", func(t *testing.T) {
+		next := *r.Current()
+		next.S3.DownloadMode = "invalid"
+
+		assert.Error(t, r.Reload(&next))
+		assert.Equal(t, "proxy", r.Current().S3.DownloadMode)
+		assert.Len(t, applied, 1)
+	})
+
+	t.Run("ignores unchanged files", func(t *testing.T) {
+		next := *r.Current()
+		require.NoError(t, r.Reload(&next))
+		assert.Len(t, applied, 1)
+	})
+}
+
+// TestReadmeReloadableKeys keeps the README list of settings that reload
+// without a restart in sync with the reload tags.
+func TestReadmeReloadableKeys(t *testing.T) {
+	data, err := os.ReadFile("../../README.md")
+	require.NoError(t, err)
+	_, list, _ := strings.Cut(string(data), "applies changes to")
+	list, _, _ = strings.Cut(list, "without a restart")
+	require.NotEmpty(t, list)
+
+	for _, key := range config.ReloadableKeys() {
+		listed := false
+		for prefix := key; strings.Contains(prefix, ".") && !listed; prefix = prefix[:strings.LastIndex(prefix, ".")] {
+			listed = strings.Contains(list, "`"+prefix+"`")
+		}
+		assert.True(t, listed, "README does not list %s as reloadable", key)
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+)
+
+// Validate reports every missing or malformed setting, one error per
+// setting, prefixed with its key. Settings owned by other packages, such as
+// download modes, are checked there.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if err := validatePort(c.App.Port); err != nil {
+		errs = append(errs, fmt.Errorf("app.port: %w", err))
+	}
+	if err := validatePort(c.Metrics.Port); err != nil {
+		errs = append(errs, fmt.Errorf("metrics.port: %w", err))
+	}
+	if c.App.Port != "" && c.App.Port == c.Metrics.Port {
+		errs = append(errs, errors.New("metrics.port: must differ from app.port"))
+	}
+	if c.App.DrainPeriod < 0 {
+		errs = append(errs, errors.New("app.drain_period: must not be negative"))
+	}
+	if c.App.ShutdownTimeout <= 0 {
+		// A zero timeout would abort in-flight requests at once.
+		errs = append(errs, errors.New("app.shutdown_timeout: must be positive"))
+	}
+	if c.App.MaxUploadSizeMB <= 0 {
+		errs = append(errs, errors.New("app.max_upload_size_mb: must be positive"))
+	}
+	if c.App.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.App.LogLevel)); err != nil {
+			errs = append(errs, fmt.Errorf("app.loglevel: unknown level %q, expected debug, info, warn or error", c.App.LogLevel))
+		}
+	}
+
+	if c.S3.Bucket == "" {
+		errs = append(errs, errors.New("s3.bucket: is required"))
+	}
+	if c.S3.Endpoint != "" {
+		if u, err := url.Parse(c.S3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("s3.endpoint: expected an http or https URL, got %q", c.S3.Endpoint))
+		}
+	}
+	if (c.S3.AccessKeyID == "") != (c.S3.SecretAccessKey == "") {
+		errs = append(errs, errors.New("s3.access_key_id: must be set together with s3.secret_access_key"))
+	}
+	if c.S3.SessionToken != "" && c.S3.AccessKeyID == "" {
+		errs = append(errs, errors.New("s3.session_token: requires s3.access_key_id"))
+	}
+
+	if c.Cache.Enabled {
+		if c.Cache.Dir == "" {
+			errs = append(errs, errors.New("cache.dir: is required when the cache is enabled"))
+		}
+		if c.Cache.MaxSizeMB <= 0 {
+			errs = append(errs, errors.New("cache.max_size_mb: must be positive when the cache is enabled"))
+		}
+	}
+	if c.Cache.ListTTL < 0 {
+		errs = append(errs, errors.New("cache.list_ttl: must not be negative"))
+	}
+
+	if c.Stats.Enabled {
+		if c.Stats.BatchSize <= 0 {
+			errs = append(errs, errors.New("stats.batch_size: must be positive when stats are enabled"))
+		}
+		if c.Stats.FlushInterval <= 0 {
+			errs = append(errs, errors.New("stats.flush_interval: must be positive when stats are enabled"))
+		}
+	}
+
+	for _, limit := range []struct {
+		class string
+		Limit
+	}{
+		{"list", c.RateLimit.List},
+		{"download", c.RateLimit.Download},
+		{"publish", c.RateLimit.Publish},
+	} {
+		if limit.Rate < 0 {
+			errs = append(errs, fmt.Errorf("rate_limit.%s.rate: must not be negative", limit.class))
+		}
+		if limit.Burst < 0 {
+			errs = append(errs, fmt.Errorf("rate_limit.%s.burst: must not be negative", limit.class))
+		}
+	}
+	if c.RateLimit.MaxProxyStreams < 0 {
+		errs = append(errs, errors.New("rate_limit.max_proxy_streams: must not be negative"))
+	}
+
+	if c.Health.CheckTimeout < 0 {
+		errs = append(errs, errors.New("health.check_timeout: must not be negative"))
+	}
+	if c.Health.IndexMaxAge < 0 {
+		errs = append(errs, errors.New("health.index_max_age: must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validatePort(port string) error {
+	if port == "" {
+		return errors.New("is required")
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("expected a port number between 1 and 65535, got %q", port)
+	}
+	return nil
+}
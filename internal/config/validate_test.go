@@ -0,0 +1,38 @@
+package config_test
+
+import (
+	"testing"
+	"time"
+
+	"miso/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	valid := config.Config{
+		App:     config.App{Port: "9000", LogLevel: "debug", ShutdownTimeout: 10 * time.Second, MaxUploadSizeMB: 512},
+		Metrics: config.Metrics{Port: "9001"},
+		S3:      config.S3{Bucket: "miso"},
+	}
+	assert.NoError(t, valid.Validate())
+
+	invalid := config.Config{
+		App:     config.App{Port: "90000", LogLevel: "loud"},
+		Metrics: config.Metrics{},
+		S3:      config.S3{Endpoint: "minio:9000", AccessKeyID: "minio"},
+		Cache:   config.Cache{Enabled: true},
+		RateLimit: config.RateLimit{
+			Download:        config.Limit{Rate: -1},
+			MaxProxyStreams: -1,
+		},
+	}
+	err := invalid.Validate()
+	for _, key := range []string{
+		"app.port:", "app.loglevel:", "app.shutdown_timeout:", "app.max_upload_size_mb:", "metrics.port:", "s3.bucket:", "s3.endpoint:",
+		"s3.access_key_id:", "cache.dir:", "cache.max_size_mb:",
+		"rate_limit.download.rate:", "rate_limit.max_proxy_streams:",
+	} {
+		assert.ErrorContains(t, err, key)
+	}
+}
@@ -0,0 +1,170 @@
+// Package download decides per request whether an artifact is served
+// through a presigned URL or proxied through miso.
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"miso/internal/config"
+)
+
+type Mode string
+
+const (
+	ModePresigned Mode = "presigned-url"
+	ModeProxy     Mode = "proxy"
+)
+
+// ParseMode validates a configured download mode. The empty string selects
+// presigned URLs.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModePresigned:
+		return ModePresigned, nil
+	case ModeProxy:
+		return ModeProxy, nil
+	}
+	return "", fmt.Errorf("unknown download mode %q, expected %q or %q", s, ModePresigned, ModeProxy)
+}
+
+// Request describes a download for policy evaluation.
+type Request struct {
+	Namespace string
+	ClientIP  netip.Addr
+	// Size returns the size of the requested object. It is only called
+	// when a rule depends on it.
+	Size func() (int64, error)
+}
+
+type Policy struct {
+	mode       Mode
+	namespaces map[string]Mode
+	rules      []rule
+}
+
+type rule struct {
+	mode    Mode
+	cidrs   []netip.Prefix
+	maxSize int64
+}
+
+// Validate reports every invalid mode, CIDR and expiry in the download
+// settings.
+func Validate(cfg config.S3) error {
+	var errs []error
+
+	if _, err := ParseMode(cfg.DownloadMode); err != nil {
+		errs = append(errs, fmt.Errorf("s3.download_mode: %w", err))
+	}
+	for namespace, mode := range cfg.DownloadPolicy.Namespaces {
+		if _, err := ParseMode(mode); err != nil || mode == "" {
+			errs = append(errs, fmt.Errorf("s3.download_policy.namespaces.%s: unknown download mode %q", namespace, mode))
+		}
+	}
+	for i, r := range cfg.DownloadPolicy.Rules {
+		if _, err := ParseMode(r.Mode); err != nil || r.Mode == "" {
+			errs = append(errs, fmt.Errorf("s3.download_policy.rules[%d].mode: unknown download mode %q", i, r.Mode))
+		}
+		for _, cidr := range r.CIDRs {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				errs = append(errs, fmt.Errorf("s3.download_policy.rules[%d].cidrs: %w", i, err))
+			}
+		}
+		if r.MaxSizeMB < 0 {
+			errs = append(errs, fmt.Errorf("s3.download_policy.rules[%d].max_size_mb: must not be negative", i))
+		}
+		if len(r.CIDRs) == 0 && r.MaxSizeMB == 0 {
+			errs = append(errs, fmt.Errorf("s3.download_policy.rules[%d]: rule has no conditions", i))
+		}
+	}
+
+	switch {
+	case cfg.PresignExpiry < 0:
+		errs = append(errs, errors.New("s3.presign_expiry: must not be negative"))
+	case cfg.PresignExpiry > maxPresignExpiry:
+		errs = append(errs, fmt.Errorf("s3.presign_expiry: must not exceed %s", maxPresignExpiry))
+	}
+
+	return errors.Join(errs...)
+}
+
+// maxPresignExpiry is the longest lifetime S3 accepts for a presigned URL.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// NewPolicy builds the policy for cfg. Invalid entries are ignored, so cfg
+// should be checked with Validate at startup.
+func NewPolicy(cfg config.S3) *Policy {
+	mode, _ := ParseMode(cfg.DownloadMode)
+	p := &Policy{
+		mode:       mode,
+		namespaces: make(map[string]Mode),
+	}
+
+	for namespace, s := range cfg.DownloadPolicy.Namespaces {
+		if m, err := ParseMode(s); err == nil && s != "" {
+			p.namespaces[namespace] = m
+		}
+	}
+	for _, r := range cfg.DownloadPolicy.Rules {
+		m, err := ParseMode(r.Mode)
+		if err != nil || r.Mode == "" {
+			continue
+		}
+		compiled := rule{mode: m, maxSize: r.MaxSizeMB << 20}
+		for _, cidr := range r.CIDRs {
+			if prefix, err := netip.ParsePrefix(cidr); err == nil {
+				compiled.cidrs = append(compiled.cidrs, prefix.Masked())
+			}
+		}
+		p.rules = append(p.rules, compiled)
+	}
+
+	return p
+}
+
+// Mode returns the download mode for req.
+func (p *Policy) Mode(req Request) (Mode, error) {
+	if m, ok := p.namespaces[req.Namespace]; ok {
+		return m, nil
+	}
+
+	var size int64 = -1
+	for _, r := range p.rules {
+		if len(r.cidrs) > 0 && !containsAddr(r.cidrs, req.ClientIP) {
+			continue
+		}
+		if r.maxSize > 0 {
+			if size < 0 {
+				if req.Size == nil {
+					continue
+				}
+				var err error
+				if size, err = req.Size(); err != nil {
+					return "", err
+				}
+			}
+			if size > r.maxSize {
+				continue
+			}
+		}
+		return r.mode, nil
+	}
+
+	return p.mode, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
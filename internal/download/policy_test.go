@@ -0,0 +1,77 @@
+package download_test
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"miso/internal/config"
+	"miso/internal/download"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, download.Validate(config.S3{}))
+	assert.NoError(t, download.Validate(config.S3{DownloadMode: "proxy"}))
+
+	err := download.Validate(config.S3{
+		DownloadMode: "proxied",
+		DownloadPolicy: config.DownloadPolicy{
+			Namespaces: map[string]string{"acme": "presigned"},
+			Rules: []config.DownloadRule{
+				{Mode: "proxy", CIDRs: []string{"10.0.0.0/33"}},
+				{Mode: "proxy"},
+			},
+		},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `s3.download_mode: unknown download mode "proxied"`)
+	assert.Contains(t, err.Error(), "s3.download_policy.namespaces.acme")
+	assert.Contains(t, err.Error(), "s3.download_policy.rules[0].cidrs")
+	assert.Contains(t, err.Error(), "s3.download_policy.rules[1]: rule has no conditions")
+}
+
+func TestPolicyMode(t *testing.T) {
+	policy := download.NewPolicy(config.S3{
+		DownloadMode: "proxy",
+		DownloadPolicy: config.DownloadPolicy{
+			Namespaces: map[string]string{"public": "presigned-url"},
+			Rules: []config.DownloadRule{
+				{Mode: "presigned-url", CIDRs: []string{"10.0.0.0/8"}},
+				{Mode: "presigned-url", MaxSizeMB: 1},
+			},
+		},
+	})
+
+	size := func(n int64) func() (int64, error) {
+		return func() (int64, error) { return n, nil }
+	}
+	internal := netip.MustParseAddr("10.1.2.3")
+	external := netip.MustParseAddr("203.0.113.7")
+
+	tests := []struct {
+		name string
+		req  download.Request
+		want download.Mode
+	}{
+		{"namespace override", download.Request{Namespace: "public", ClientIP: external, Size: size(10 << 20)}, download.ModePresigned},
+		{"internal client", download.Request{Namespace: "acme", ClientIP: internal, Size: size(10 << 20)}, download.ModePresigned},
+		{"small object", download.Request{Namespace: "acme", ClientIP: external, Size: size(1 << 20)}, download.ModePresigned},
+		{"default", download.Request{Namespace: "acme", ClientIP: external, Size: size(10 << 20)}, download.ModeProxy},
+		{"ipv4-mapped client", download.Request{ClientIP: netip.MustParseAddr("::ffff:10.0.0.1")}, download.ModePresigned},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := policy.Mode(tt.req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, mode)
+		})
+	}
+
+	t.Run("size-error", func(t *testing.T) {
+		_, err := policy.Mode(download.Request{ClientIP: external, Size: func() (int64, error) { return 0, errors.New("boom") }})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"miso/internal/audit"
+	"miso/internal/module"
+	"miso/internal/registry"
+	"miso/internal/webhook"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) PublishModule(c echo.Context) error {
+	meta := module.VersionMetadata{
+		Owner:       c.QueryParam("owner"),
+		Description: c.QueryParam("description"),
+		Source:      c.QueryParam("source"),
+	}
+
+	namespace, name, provider, version := c.Param("namespace"), c.Param("name"), c.Param("provider"), c.Param("version")
+	setStorageKey(c, registry.ModuleArchiveKey(namespace, name, provider, version))
+
+	err := h.registry(c).PublishModule(namespace, name, provider, version, c.Request().Body, meta)
+	if err != nil {
+		return registryError(err)
+	}
+	h.log(c).Info("module published", slog.String("module", namespace+"/"+name+"/"+provider), slog.String("version", version))
+	h.notify(c, webhook.Event{Type: webhook.ModulePublished, Kind: "module", Namespace: namespace, Name: name, Provider: provider, Version: version})
+
+	return c.NoContent(http.StatusCreated)
+}
+
+func (h *Handler) DeleteModuleVersion(c echo.Context) error {
+	namespace, name, provider, version := c.Param("namespace"), c.Param("name"), c.Param("provider"), c.Param("version")
+	setStorageKey(c, registry.ModulePrefix(namespace, name, provider)+version+"/")
+
+	err := h.registry(c).DeleteModuleVersion(namespace, name, provider, version)
+	if err != nil {
+		return registryError(err)
+	}
+	h.log(c).Info("module version deleted", slog.String("module", namespace+"/"+name+"/"+provider), slog.String("version", version))
+	h.notify(c, webhook.Event{Type: webhook.VersionDeleted, Kind: "module", Namespace: namespace, Name: name, Provider: provider, Version: version})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) YankModuleVersion(c echo.Context) error {
+	namespace, name, provider, version := c.Param("namespace"), c.Param("name"), c.Param("provider"), c.Param("version")
+	setStorageKey(c, registry.ModuleMetadataKey(namespace, name, provider, version))
+
+	err := h.registry(c).YankModuleVersion(namespace, name, provider, version)
+	if err != nil {
+		return registryError(err)
+	}
+	h.log(c).Info("module version yanked", slog.String("module", namespace+"/"+name+"/"+provider), slog.String("version", version))
+	h.notify(c, webhook.Event{Type: webhook.VersionYanked, Kind: "module", Namespace: namespace, Name: name, Provider: provider, Version: version})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) PublishProvider(c echo.Context) error {
+	namespace, typeName, version, os, arch := c.Param("namespace"), c.Param("type"), c.Param("version"), c.Param("os"), c.Param("arch")
+	setStorageKey(c, registry.ProviderBinaryKey(namespace, typeName, version, os, arch))
+
+	err := h.registry(c).PublishProvider(namespace, typeName, version, os, arch, c.Request().Body)
+	if err != nil {
+		return registryError(err)
+	}
+	h.log(c).Info("provider published", slog.String("provider", namespace+"/"+typeName), slog.String("version", version), slog.String("platform", os+"_"+arch))
+	h.notify(c, webhook.Event{Type: webhook.ProviderPublished, Kind: "provider", Namespace: namespace, Name: typeName, Version: version, OS: os, Arch: arch})
+
+	return c.NoContent(http.StatusCreated)
+}
+
+func (h *Handler) DeleteProviderVersion(c echo.Context) error {
+	namespace, typeName, version := c.Param("namespace"), c.Param("type"), c.Param("version")
+	setStorageKey(c, registry.ProviderPrefix(namespace, typeName)+version+"/")
+
+	err := h.registry(c).DeleteProviderVersion(namespace, typeName, version)
+	if err != nil {
+		return registryError(err)
+	}
+	h.log(c).Info("provider version deleted", slog.String("provider", namespace+"/"+typeName), slog.String("version", version))
+	h.notify(c, webhook.Event{Type: webhook.VersionDeleted, Kind: "provider", Namespace: namespace, Name: typeName, Version: version})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// notify sends e to the subscribed webhooks, if any are configured.
+func (h *Handler) notify(c echo.Context, e webhook.Event) {
+	if h.Webhooks == nil {
+		return
+	}
+	e.Principal = Principal(c)
+	if err := h.Webhooks.Publish(e); err != nil {
+		h.log(c).Warn("could not publish webhook event", slog.String("event", e.Type), slog.String("err", err.Error()))
+	}
+}
+
+// FlushCache drops every cached version listing.
+func (h *Handler) FlushCache(c echo.Context) error {
+	flushed := 0
+	if h.Registry.Versions != nil {
+		flushed = h.Registry.Versions.Flush()
+	}
+	h.log(c).Info("version cache flushed", slog.Int("entries", flushed))
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"flushed": flushed,
+	})
+}
+
+// QueryAudit returns the audit events in the time range given by the from
+// and to RFC 3339 query parameters, optionally only those of one actor.
+func (h *Handler) QueryAudit(c echo.Context) error {
+	if h.Audit == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "audit trail is disabled")
+	}
+
+	from, err := queryTime(c, "from")
+	if err != nil {
+		return err
+	}
+	to, err := queryTime(c, "to")
+	if err != nil {
+		return err
+	}
+
+	events, err := h.Audit.Query(audit.Filter{From: from, To: to, Actor: c.QueryParam("actor")})
+	if err != nil {
+		return err
+	}
+	if events == nil {
+		events = []audit.Event{}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"events": events,
+	})
+}
+
+// queryTime parses the RFC 3339 time in query parameter name, if present.
+func queryTime(c echo.Context, name string) (time.Time, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, name+" must be an RFC 3339 time")
+	}
+	return t, nil
+}
+
+// registryError maps registry errors to HTTP errors.
+func registryError(err error) error {
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, registry.ErrInvalidVersion),
+		errors.Is(err, registry.ErrInvalidName),
+		errors.Is(err, registry.ErrInvalidArchive):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+
+	"miso/internal/audit"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Keys of the values handlers attach to the request context for logging.
+const (
+	principalKey  = "principal"
+	storageKeyKey = "storage_key"
+)
+
+// SetPrincipal records who the request was authenticated as.
+func SetPrincipal(c echo.Context, principal string) {
+	c.Set(principalKey, principal)
+}
+
+// Principal returns the authenticated caller of the request, or "" for
+// anonymous requests.
+func Principal(c echo.Context) string {
+	principal, _ := c.Get(principalKey).(string)
+	return principal
+}
+
+func setStorageKey(c echo.Context, key string) {
+	c.Set(storageKeyKey, key)
+}
+
+// StorageKey returns the object key the request read or wrote, if any.
+func StorageKey(c echo.Context) string {
+	key, _ := c.Get(storageKeyKey).(string)
+	return key
+}
+
+// RequestID returns the ID of the request, as set by the request ID
+// middleware.
+func RequestID(c echo.Context) string {
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
+
+// log returns the handler logger annotated with the request ID and
+// principal.
+func (h *Handler) log(c echo.Context) *slog.Logger {
+	logger := h.Logger.With(slog.String("request_id", RequestID(c)))
+	if principal := Principal(c); principal != "" {
+		logger = logger.With(slog.String("principal", principal))
+	}
+	return logger
+}
+
+// actorContext returns the request context carrying the caller, for the
+// audit trail.
+func actorContext(c echo.Context) context.Context {
+	return audit.WithActor(c.Request().Context(), audit.Actor{
+		Principal: Principal(c),
+		SourceIP:  c.RealIP(),
+		RequestID: RequestID(c),
+	})
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"miso/internal/download"
+	"miso/internal/module"
+	"miso/internal/stats"
+
+	"github.com/labstack/echo/v4"
+)
+
+// moduleDownloaded counts a module download in the metrics and records it
+// in the download statistics. HEAD requests only ask about the artifact
+// and are not counted.
+func (h *Handler) moduleDownloaded(c echo.Context, namespace, name, provider, version string, mode download.Mode) {
+	if c.Request().Method == http.MethodHead {
+		return
+	}
+	observeModuleDownload(namespace, name, provider, version, mode)
+	h.recordDownload(c, stats.Event{
+		Kind:      stats.KindModule,
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+		Mode:      string(mode),
+	})
+}
+
+// providerDownloaded counts a provider download in the metrics and records
+// it in the download statistics, except for HEAD requests.
+func (h *Handler) providerDownloaded(c echo.Context, namespace, typeName, version, os, arch string, mode download.Mode) {
+	if c.Request().Method == http.MethodHead {
+		return
+	}
+	observeProviderDownload(namespace, typeName, version, os, arch, mode)
+	h.recordDownload(c, stats.Event{
+		Kind:      stats.KindProvider,
+		Namespace: namespace,
+		Name:      typeName,
+		Version:   version,
+		OS:        os,
+		Arch:      arch,
+		Mode:      string(mode),
+	})
+}
+
+func (h *Handler) recordDownload(c echo.Context, e stats.Event) {
+	if h.Recorder == nil {
+		return
+	}
+	e.Principal = Principal(c)
+	e.Time = time.Now().UTC()
+	h.Recorder.Record(e)
+}
+
+// ModuleDownloadsSummary returns the download counts of a module in the
+// format of the public registry's downloads summary.
+func (h *Handler) ModuleDownloadsSummary(c echo.Context) error {
+	if h.Stats == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "download statistics are disabled")
+	}
+	namespace, name, provider := c.Param("namespace"), c.Param("name"), c.Param("provider")
+
+	counts, err := h.Stats.ModuleCounts(namespace, name, provider)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       "module-downloads-summary",
+			"id":         namespace + "/" + name + "/" + provider,
+			"attributes": counts.Summary(time.Now()),
+		},
+	})
+}
+
+// setDownloads fills in the total download count of each module.
+func (h *Handler) setDownloads(summaries ...*module.Summary) error {
+	if h.Stats == nil {
+		return nil
+	}
+	totals, err := h.Stats.ModuleTotals()
+	if err != nil {
+		return err
+	}
+	for _, s := range summaries {
+		s.Downloads = totals[s.Namespace+"/"+s.Name+"/"+s.Provider]
+	}
+	return nil
+}
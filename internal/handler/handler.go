@@ -1,46 +1,101 @@
 package handler
 
 import (
-	"io"
+	"errors"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/netip"
+	"path"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"miso/internal/audit"
 	"miso/internal/config"
+	"miso/internal/download"
+	"miso/internal/markdown"
+	"miso/internal/module"
+	"miso/internal/ratelimit"
+	"miso/internal/registry"
+	"miso/internal/stats"
 	"miso/internal/storage"
+	"miso/internal/webhook"
 
 	"github.com/labstack/echo/v4"
 )
 
 type Handler struct {
-	Storage storage.Storage
-	Config  config.S3
+	Storage  storage.Storage
+	Registry *registry.Registry
+	Config   config.S3
+	Logger   *slog.Logger
+	// Recorder and Stats are set when download statistics are enabled.
+	Recorder *stats.Recorder
+	Stats    *stats.Store
+	// Audit is set when the audit trail is enabled.
+	Audit *audit.Log
+	// Webhooks is notified of publications and deletions when set.
+	Webhooks *webhook.Dispatcher
+
+	// Middleware wraps the routes of each class, e.g. to rate limit them.
+	Middleware map[RouteClass][]echo.MiddlewareFunc
+	// MaxStreams caps the proxied downloads in progress. There is no cap
+	// when it is zero.
+	MaxStreams int64
+
+	// streams counts the proxied downloads in progress.
+	streams atomic.Int64
+
+	policy atomic.Pointer[download.Policy]
 }
 
 func NewHandler(storage storage.Storage, config config.S3) *Handler {
-	return &Handler{
-		Storage: storage,
-		Config:  config,
+	h := &Handler{
+		Storage:  storage,
+		Registry: registry.New(storage),
+		Config:   config,
+		Logger:   slog.Default(),
+	}
+	h.SetPolicy(download.NewPolicy(config))
+	return h
+}
+
+// storage returns the storage to use for the request, attributed to it when
+// the storage supports that.
+func (h *Handler) storage(c echo.Context) storage.Storage {
+	if s, ok := h.Storage.(storage.ContextStorage); ok {
+		return s.WithContext(actorContext(c))
 	}
+	return h.Storage
+}
+
+// registry returns the registry to use for the request, see storage.
+func (h *Handler) registry(c echo.Context) *registry.Registry {
+	if _, ok := h.Storage.(storage.ContextStorage); ok {
+		return h.Registry.WithStorage(h.storage(c))
+	}
+	return h.Registry
+}
+
+// SetPolicy replaces the download policy. Requests already being served
+// keep the policy they started with.
+func (h *Handler) SetPolicy(policy *download.Policy) {
+	h.policy.Store(policy)
 }
 
 func (h *Handler) ListProviderVersions(c echo.Context) error {
 	namespace := c.Param("namespace")
 	typeName := c.Param("type")
 
-	prefix := "providers/" + namespace + "/" + typeName + "/"
-	keys, err := h.Storage.List(prefix)
+	found, err := h.registry(c).ListProviderVersions(namespace, typeName)
 	if err != nil {
 		return err
 	}
 
-	versionSet := make(map[string]struct{})
-	for _, key := range keys {
-		version := strings.Split(strings.TrimPrefix(key, prefix), "/")[0]
-		versionSet[version] = struct{}{}
-	}
-
 	versions := []map[string]interface{}{}
-	for version := range versionSet {
+	for _, version := range found {
 		versions = append(versions, map[string]interface{}{"version": version})
 	}
 
@@ -56,16 +111,29 @@ func (h *Handler) DownloadProviderVersion(c echo.Context) error {
 	os := c.Param("os")
 	arch := c.Param("arch")
 
-	key := "providers/" + namespace + "/" + typeName + "/" + version + "/" + os + "/" + arch + "/terraform-provider-" + typeName + "_v" + version
+	key := registry.ProviderBinaryKey(namespace, typeName, version, os, arch)
+	setStorageKey(c, key)
 
-	if h.Config.DownloadMode == "proxy" {
-		return h.proxyDownload(c, key)
+	mode, err := h.downloadMode(c, namespace, key)
+	if err != nil {
+		return err
+	}
+	if mode == download.ModeProxy {
+		err := h.proxyDownload(c, "provider", key, registry.ProviderBinaryName(typeName, version))
+		if err == nil && delivered(c) {
+			h.providerDownloaded(c, namespace, typeName, version, os, arch, mode)
+		}
+		return err
 	}
 
-	downloadURL, err := h.Storage.GetPresignedURL(key)
+	if _, err := h.statObject(c, key); err != nil {
+		return err
+	}
+	downloadURL, err := h.presignedURL(c, key)
 	if err != nil {
 		return err
 	}
+	h.providerDownloaded(c, namespace, typeName, version, os, arch, mode)
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"download_url": downloadURL,
@@ -77,59 +145,382 @@ func (h *Handler) ListModuleVersions(c echo.Context) error {
 	name := c.Param("name")
 	provider := c.Param("provider")
 
-	prefix := "modules/" + namespace + "/" + name + "/" + provider + "/"
-	keys, err := h.Storage.List(prefix)
+	found, err := h.registry(c).ListModuleVersions(namespace, name, provider)
 	if err != nil {
 		return err
 	}
 
-	versionSet := make(map[string]struct{})
-	for _, key := range keys {
-		version := strings.Split(strings.TrimPrefix(key, prefix), "/")[0]
-		versionSet[version] = struct{}{}
+	versions := []module.Version{}
+	for _, version := range found {
+		versions = append(versions, module.Version{Version: version})
 	}
 
-	versions := []map[string]interface{}{}
-	for version := range versionSet {
-		versions = append(versions, map[string]interface{}{"version": version})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"modules": []module.Metadata{
+			{Versions: versions},
+		},
+	})
+}
+
+// GetModule returns the details of a module version, or of the latest
+// version when the route has no version parameter.
+func (h *Handler) GetModule(c echo.Context) error {
+	detail, err := h.registry(c).ModuleDetail(c.Param("namespace"), c.Param("name"), c.Param("provider"), c.Param("version"))
+	if errors.Is(err, registry.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "module not found")
+	}
+	if err != nil {
+		return err
+	}
+	if err := h.setDownloads(&detail.Summary); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, detail)
+}
+
+// ModuleReadme returns the README of a module version as markdown. The
+// path query parameter selects a submodule or example, e.g.
+// "examples/basic".
+func (h *Handler) ModuleReadme(c echo.Context) error {
+	readme, err := h.moduleReadme(c)
+	if err != nil {
+		return err
+	}
+
+	return c.Blob(http.StatusOK, "text/markdown; charset=UTF-8", readme)
+}
+
+// ModuleReadmeHTML returns the README of a module version rendered to
+// sanitized HTML.
+func (h *Handler) ModuleReadmeHTML(c echo.Context) error {
+	readme, err := h.moduleReadme(c)
+	if err != nil {
+		return err
+	}
+
+	html, err := markdown.Render(readme)
+	if err != nil {
+		return err
+	}
+
+	return c.HTMLBlob(http.StatusOK, html)
+}
+
+func (h *Handler) moduleReadme(c echo.Context) ([]byte, error) {
+	readme, err := h.registry(c).ModuleReadme(c.Param("namespace"), c.Param("name"), c.Param("provider"), c.Param("version"), c.QueryParam("path"))
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "readme not found")
+	}
+
+	return readme, err
+}
+
+// ListModuleExamples returns the examples published with a module version
+// with their inputs and outputs.
+func (h *Handler) ListModuleExamples(c echo.Context) error {
+	docs, err := h.registry(c).ModuleDocs(c.Param("namespace"), c.Param("name"), c.Param("provider"), c.Param("version"))
+	if err != nil {
+		return err
+	}
+	if docs == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "module not found")
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"modules": []map[string]interface{}{
-			{"versions": versions},
-		},
+		"examples": docs.Examples,
 	})
 }
 
+// ListModuleProviders returns the latest version of a module for every
+// provider it is published for.
+func (h *Handler) ListModuleProviders(c echo.Context) error {
+	namespace := c.Param("namespace")
+	name := c.Param("name")
+
+	modules, err := h.registry(c).SearchModules(registry.ModuleQuery{Namespace: namespace})
+	if err != nil {
+		return err
+	}
+
+	details := []module.Detail{}
+	for _, m := range modules {
+		if m.Name != name {
+			continue
+		}
+		detail, err := h.registry(c).ModuleDetail(m.Namespace, m.Name, m.TargetSystem, "")
+		if err != nil {
+			return err
+		}
+		details = append(details, detail)
+	}
+	if len(details) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "module not found")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"modules": details,
+	})
+}
+
+// DownloadLatestModule redirects to the download endpoint of the newest
+// version of a module.
+func (h *Handler) DownloadLatestModule(c echo.Context) error {
+	versions, err := h.registry(c).ListModuleVersions(c.Param("namespace"), c.Param("name"), c.Param("provider"))
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "module not found")
+	}
+
+	base := strings.TrimSuffix(c.Request().URL.Path, "download")
+	return c.Redirect(http.StatusFound, base+versions[len(versions)-1]+"/download")
+}
+
 func (h *Handler) DownloadModuleVersion(c echo.Context) error {
 	namespace := c.Param("namespace")
 	name := c.Param("name")
 	provider := c.Param("provider")
 	version := c.Param("version")
 
-	key := "modules/" + namespace + "/" + name + "/" + provider + "/" + version + "/module.zip"
+	key := registry.ModuleArchiveKey(namespace, name, provider, version)
+	setStorageKey(c, key)
 
-	if h.Config.DownloadMode == "proxy" {
-		return h.proxyDownload(c, key)
+	mode, err := h.downloadMode(c, namespace, key)
+	if err != nil {
+		return err
+	}
+	if mode == download.ModeProxy {
+		err := h.proxyDownload(c, "module", key, name+"-"+provider+"-"+version+".zip")
+		if err == nil && delivered(c) {
+			h.moduleDownloaded(c, namespace, name, provider, version, mode)
+		}
+		return err
 	}
 
-	downloadURL, err := h.Storage.GetPresignedURL(key)
+	if _, err := h.statObject(c, key); err != nil {
+		return err
+	}
+	downloadURL, err := h.presignedURL(c, key)
 	if err != nil {
 		return err
 	}
+	h.moduleDownloaded(c, namespace, name, provider, version, mode)
 
 	return c.Redirect(http.StatusFound, downloadURL)
 }
 
-func (h *Handler) proxyDownload(c echo.Context, key string) error {
-	stream, err := h.Storage.GetStream(key)
+// statObject returns the metadata of key, or a 404 when it does not exist.
+// Downloads are only counted and recorded once their artifact is known to
+// exist, so that requests for made-up versions create no metrics series or
+// statistics objects.
+func (h *Handler) statObject(c echo.Context, key string) (*storage.ObjectInfo, error) {
+	info, err := h.storage(c).Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "file not found")
+	}
+	return info, nil
+}
+
+// presignedURL returns the download URL of key.
+func (h *Handler) presignedURL(c echo.Context, key string) (string, error) {
+	downloadURL, err := h.storage(c).GetPresignedURL(key)
+	if err != nil {
+		presignedURLs.WithLabelValues("error").Inc()
+		return "", err
+	}
+	presignedURLs.WithLabelValues("success").Inc()
+
+	return downloadURL, nil
+}
+
+// downloadMode asks the download policy how to serve key to the client.
+func (h *Handler) downloadMode(c echo.Context, namespace, key string) (download.Mode, error) {
+	clientIP, _ := netip.ParseAddr(c.RealIP())
+
+	return h.policy.Load().Mode(download.Request{
+		Namespace: namespace,
+		ClientIP:  clientIP,
+		Size: func() (int64, error) {
+			info, err := h.storage(c).Stat(key)
+			if err != nil || info == nil {
+				return 0, err
+			}
+			return info.Size, nil
+		},
+	})
+}
+
+// proxyDownload streams an object through miso. Range, conditional and
+// HEAD requests are answered by http.ServeContent from the object metadata,
+// fetching only the requested byte ranges from storage.
+func (h *Handler) proxyDownload(c echo.Context, kind, key, filename string) error {
+	info, err := h.statObject(c, key)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = stream.Close() }()
 
-	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
-	c.Response().WriteHeader(http.StatusOK)
-	_, err = io.Copy(c.Response().Writer, stream)
-	return err
+	contentType := info.ContentType
+	if contentType == "" || contentType == "binary/octet-stream" {
+		contentType = mime.TypeByExtension(path.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if info.ETag != "" {
+		etag := info.ETag
+		if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+			etag = `"` + etag + `"`
+		}
+		header.Set("ETag", etag)
+	}
+
+	content := storage.NewReadSeeker(h.storage(c), key, info)
+	content.ExpectRanges(c.Request().Header.Get("Range"))
+	defer func() { _ = content.Close() }()
+
+	if n := h.streams.Add(1); h.MaxStreams > 0 && n > h.MaxStreams {
+		h.streams.Add(-1)
+		return ratelimit.Reject(c, "stream", streamRetryAfter)
+	}
+	activeStreams.Inc()
+	defer func() {
+		h.streams.Add(-1)
+		activeStreams.Dec()
+	}()
+
+	http.ServeContent(c.Response(), c.Request(), filename, info.LastModified, content)
+	proxiedBytes.WithLabelValues(kind).Add(float64(c.Response().Size))
+	return nil
+}
+
+// streamRetryAfter is when clients rejected by the stream cap are asked to
+// retry.
+const streamRetryAfter = 5 * time.Second
+
+// ActiveStreams returns the number of proxied downloads in progress.
+func (h *Handler) ActiveStreams() int64 {
+	return h.streams.Load()
+}
+
+// delivered reports whether a proxied download sent the whole artifact, as
+// opposed to a conditional, ranged or failed response.
+func delivered(c echo.Context) bool {
+	return c.Response().Status == http.StatusOK
+}
+
+func (h *Handler) ListModules(c echo.Context) error {
+	return h.searchModules(c, registry.ModuleQuery{
+		Namespace: c.Param("namespace"),
+		Provider:  c.QueryParam("provider"),
+	})
+}
+
+func (h *Handler) SearchModules(c echo.Context) error {
+	q := c.QueryParam("q")
+	if strings.TrimSpace(q) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "q parameter is required")
+	}
+
+	return h.searchModules(c, registry.ModuleQuery{
+		Namespace: c.QueryParam("namespace"),
+		Provider:  c.QueryParam("provider"),
+		Query:     q,
+	})
+}
+
+func (h *Handler) searchModules(c echo.Context, query registry.ModuleQuery) error {
+	limit, offset, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+
+	modules, err := h.registry(c).SearchModules(query)
+	if err != nil {
+		return err
+	}
+
+	page := []module.Summary{}
+	for i := offset; i < len(modules) && i < offset+limit; i++ {
+		page = append(page, modules[i].Summary())
+	}
+	summaries := make([]*module.Summary, len(page))
+	for i := range page {
+		summaries[i] = &page[i]
+	}
+	if err := h.setDownloads(summaries...); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, module.List{
+		Meta:    pageMeta(c, limit, offset, len(modules)),
+		Modules: page,
+	})
+}
+
+const (
+	defaultPageLimit = 15
+	maxPageLimit     = 100
+)
+
+// pageParams reads the limit and offset query parameters used by the
+// listing endpoints.
+func pageParams(c echo.Context) (int, int, error) {
+	limit, offset := defaultPageLimit, 0
+
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		limit = min(n, maxPageLimit)
+	}
+	if v := c.QueryParam("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "offset must be a non-negative integer")
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+// pageMeta builds the pagination metadata for a page of a listing with
+// total results. Next and previous URLs keep every other query parameter.
+func pageMeta(c echo.Context, limit, offset, total int) module.Meta {
+	meta := module.Meta{
+		Limit:         limit,
+		CurrentOffset: offset,
+	}
+
+	pageURL := func(offset int) string {
+		u := *c.Request().URL
+		q := u.Query()
+		q.Set("limit", strconv.Itoa(limit))
+		q.Set("offset", strconv.Itoa(offset))
+		u.RawQuery = q.Encode()
+		return u.RequestURI()
+	}
+
+	if offset+limit < total {
+		next := offset + limit
+		meta.NextOffset = &next
+		meta.NextURL = pageURL(next)
+	}
+	if offset > 0 {
+		prev := max(offset-limit, 0)
+		meta.PrevOffset = &prev
+		meta.PrevURL = pageURL(prev)
+	}
+
+	return meta
 }
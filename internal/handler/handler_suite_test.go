@@ -1,18 +1,29 @@
 package handler_test
 
 import (
+	"encoding/json"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"miso/internal/audit"
 	"miso/internal/config"
 	"miso/internal/handler"
+	"miso/internal/registry"
+	"miso/internal/stats"
 	"miso/internal/storage"
+	"miso/internal/webhook"
 
 	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestListProviderVersions(t *testing.T) {
@@ -100,6 +111,9 @@ func TestDownloadProviderVersion(t *testing.T) {
 			GetPresignedURLFunc: func(key string) (string, error) {
 				return "https://example.com/download", nil
 			},
+			StatFunc: func(key string) (*storage.ObjectInfo, error) {
+				return &storage.ObjectInfo{Size: 12}, nil
+			},
 		}
 
 		cfg := config.S3{DownloadMode: "presigned-url"}
@@ -124,6 +138,9 @@ func TestDownloadProviderVersion(t *testing.T) {
 			GetStreamFunc: func(key string) (io.ReadCloser, error) {
 				return io.NopCloser(strings.NewReader("file content")), nil
 			},
+			StatFunc: func(key string) (*storage.ObjectInfo, error) {
+				return &storage.ObjectInfo{Size: 12, ETag: `"abc"`}, nil
+			},
 		}
 
 		cfg := config.S3{DownloadMode: "proxy"}
@@ -132,6 +149,10 @@ func TestDownloadProviderVersion(t *testing.T) {
 		if assert.NoError(t, h.DownloadProviderVersion(c)) {
 			assert.Equal(t, http.StatusOK, rec.Code)
 			assert.Equal(t, "file content", rec.Body.String())
+			assert.Equal(t, "12", rec.Header().Get(echo.HeaderContentLength))
+			assert.Equal(t, `"abc"`, rec.Header().Get("ETag"))
+			assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+			assert.Equal(t, `attachment; filename=terraform-provider-my-type_v1.0.0`, rec.Header().Get(echo.HeaderContentDisposition))
 		}
 	})
 }
@@ -150,6 +171,9 @@ func TestDownloadModuleVersion(t *testing.T) {
 			GetPresignedURLFunc: func(key string) (string, error) {
 				return "https://example.com/download", nil
 			},
+			StatFunc: func(key string) (*storage.ObjectInfo, error) {
+				return &storage.ObjectInfo{Size: 12}, nil
+			},
 		}
 
 		cfg := config.S3{DownloadMode: "presigned-url"}
@@ -174,6 +198,9 @@ func TestDownloadModuleVersion(t *testing.T) {
 			GetStreamFunc: func(key string) (io.ReadCloser, error) {
 				return io.NopCloser(strings.NewReader("file content")), nil
 			},
+			StatFunc: func(key string) (*storage.ObjectInfo, error) {
+				return &storage.ObjectInfo{Size: 12, ETag: `"abc"`}, nil
+			},
 		}
 
 		cfg := config.S3{DownloadMode: "proxy"}
@@ -182,6 +209,786 @@ func TestDownloadModuleVersion(t *testing.T) {
 		if assert.NoError(t, h.DownloadModuleVersion(c)) {
 			assert.Equal(t, http.StatusOK, rec.Code)
 			assert.Equal(t, "file content", rec.Body.String())
+			assert.Equal(t, "application/zip", rec.Header().Get(echo.HeaderContentType))
+			assert.Equal(t, `attachment; filename=my-module-my-provider-1.0.0.zip`, rec.Header().Get(echo.HeaderContentDisposition))
+		}
+	})
+}
+
+func TestDownloadPolicy(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:40000"
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("namespace", "name", "provider", "version")
+	c.SetParamValues("my-namespace", "my-module", "my-provider", "1.0.0")
+
+	storage := &storage.MockStorage{
+		GetPresignedURLFunc: func(key string) (string, error) {
+			return "https://example.com/download", nil
+		},
+		StatFunc: func(key string) (*storage.ObjectInfo, error) {
+			return &storage.ObjectInfo{Size: 12}, nil
+		},
+	}
+
+	cfg := config.S3{
+		DownloadMode: "proxy",
+		DownloadPolicy: config.DownloadPolicy{
+			Rules: []config.DownloadRule{{Mode: "presigned-url", CIDRs: []string{"10.0.0.0/8"}}},
+		},
+	}
+	h := handler.NewHandler(storage, cfg)
+
+	if assert.NoError(t, h.DownloadModuleVersion(c)) {
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "https://example.com/download", rec.Header().Get(echo.HeaderLocation))
+		assert.Equal(t, "modules/my-namespace/my-module/my-provider/1.0.0/module.zip", handler.StorageKey(c))
+	}
+}
+
+func TestProxyDownloadConditional(t *testing.T) {
+	newHandler := func() (*handler.Handler, *[]string) {
+		var ranges []string
+		s := &storage.MockStorage{
+			StatFunc: func(key string) (*storage.ObjectInfo, error) {
+				return &storage.ObjectInfo{Size: 12, ETag: `"abc"`}, nil
+			},
+			GetRangeFunc: func(key string, offset, length int64) (io.ReadCloser, error) {
+				ranges = append(ranges, strconv.FormatInt(offset, 10)+"+"+strconv.FormatInt(length, 10))
+				content := "file content"[offset:]
+				if length >= 0 {
+					content = content[:length]
+				}
+				return io.NopCloser(strings.NewReader(content)), nil
+			},
+		}
+		return handler.NewHandler(s, config.S3{DownloadMode: "proxy"}), &ranges
+	}
+	newContext := func(header ...string) (echo.Context, *httptest.ResponseRecorder) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("namespace", "name", "provider", "version")
+		c.SetParamValues("my-namespace", "my-module", "my-provider", "1.0.0")
+		return c, rec
+	}
+
+	t.Run("range", func(t *testing.T) {
+		h, ranges := newHandler()
+		c, rec := newContext("Range", "bytes=5-")
+		if assert.NoError(t, h.DownloadModuleVersion(c)) {
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "content", rec.Body.String())
+			assert.Equal(t, "bytes 5-11/12", rec.Header().Get("Content-Range"))
+			assert.Equal(t, []string{"5+7"}, *ranges)
+		}
+	})
+
+	t.Run("bounded-range", func(t *testing.T) {
+		h, ranges := newHandler()
+		c, rec := newContext("Range", "bytes=0-3")
+		if assert.NoError(t, h.DownloadModuleVersion(c)) {
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Equal(t, "file", rec.Body.String())
+			assert.Equal(t, []string{"0+4"}, *ranges)
+		}
+	})
+
+	t.Run("multi-range", func(t *testing.T) {
+		h, ranges := newHandler()
+		c, rec := newContext("Range", "bytes=0-3,-7")
+		if assert.NoError(t, h.DownloadModuleVersion(c)) {
+			assert.Equal(t, http.StatusPartialContent, rec.Code)
+			assert.Contains(t, rec.Header().Get(echo.HeaderContentType), "multipart/byteranges")
+			assert.Contains(t, rec.Body.String(), "file")
+			assert.Contains(t, rec.Body.String(), "content")
+			assert.Equal(t, []string{"0+4", "5+7"}, *ranges)
+		}
+	})
+
+	t.Run("stale-if-range", func(t *testing.T) {
+		h, ranges := newHandler()
+		c, rec := newContext("Range", "bytes=0-3", "If-Range", `"old"`)
+		if assert.NoError(t, h.DownloadModuleVersion(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "file content", rec.Body.String())
+			assert.Equal(t, []string{"0+4", "4+-1"}, *ranges)
+		}
+	})
+
+	t.Run("if-none-match", func(t *testing.T) {
+		h, ranges := newHandler()
+		c, rec := newContext("If-None-Match", `"abc"`)
+		if assert.NoError(t, h.DownloadModuleVersion(c)) {
+			assert.Equal(t, http.StatusNotModified, rec.Code)
+			assert.Empty(t, *ranges)
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		h, ranges := newHandler()
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodHead, "/", nil), rec)
+		c.SetParamNames("namespace", "name", "provider", "version")
+		c.SetParamValues("my-namespace", "head-module", "my-provider", "1.0.0")
+		if assert.NoError(t, h.DownloadModuleVersion(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "12", rec.Header().Get(echo.HeaderContentLength))
+			assert.Empty(t, rec.Body.String())
+			assert.Empty(t, *ranges)
+			assert.Equal(t, 0.0, counterValue(t, "miso_module_downloads_total", map[string]string{"name": "head-module"}))
+		}
+	})
+
+	t.Run("not-found", func(t *testing.T) {
+		h := handler.NewHandler(&storage.MockStorage{}, config.S3{DownloadMode: "proxy"})
+		c, _ := newContext("Accept", "*/*")
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, h.DownloadModuleVersion(c), &he) {
+			assert.Equal(t, http.StatusNotFound, he.Code)
+		}
+	})
+}
+
+func moduleStorage() *storage.MockStorage {
+	return &storage.MockStorage{
+		ListFunc: func(prefix string) ([]string, error) {
+			keys := []string{
+				"modules/acme/vpc/aws/1.0.0/module.zip",
+				"modules/acme/vpc/aws/1.2.0/module.zip",
+				"modules/acme/vpc/google/0.1.0/module.zip",
+				"modules/other/dns/aws/2.0.0/module.zip",
+			}
+			var matched []string
+			for _, key := range keys {
+				if strings.HasPrefix(key, prefix) {
+					matched = append(matched, key)
+				}
+			}
+			return matched, nil
+		},
+	}
+}
+
+func TestListModules(t *testing.T) {
+	t.Run("all", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/v1/modules?limit=2", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/v1/modules")
+
+		h := handler.NewHandler(moduleStorage(), config.S3{})
+
+		if assert.NoError(t, h.ListModules(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{
+				"meta":{"limit":2,"current_offset":0,"next_offset":2,"next_url":"/v1/modules?limit=2&offset=2"},
+				"modules":[
+					{"id":"acme/vpc/aws/1.2.0","namespace":"acme","name":"vpc","provider":"aws","version":"1.2.0","description":"","owner":"","source":"","downloads":0},
+					{"id":"acme/vpc/google/0.1.0","namespace":"acme","name":"vpc","provider":"google","version":"0.1.0","description":"","owner":"","source":"","downloads":0}
+				]
+			}`, rec.Body.String())
 		}
 	})
+
+	t.Run("namespace-and-provider", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/v1/modules/acme?provider=aws", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/v1/modules/:namespace")
+		c.SetParamNames("namespace")
+		c.SetParamValues("acme")
+
+		h := handler.NewHandler(moduleStorage(), config.S3{})
+
+		if assert.NoError(t, h.ListModules(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{
+				"meta":{"limit":15,"current_offset":0},
+				"modules":[
+					{"id":"acme/vpc/aws/1.2.0","namespace":"acme","name":"vpc","provider":"aws","version":"1.2.0","description":"","owner":"","source":"","downloads":0}
+				]
+			}`, rec.Body.String())
+		}
+	})
+
+	t.Run("invalid-limit", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/v1/modules?limit=-1", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		h := handler.NewHandler(moduleStorage(), config.S3{})
+
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, h.ListModules(c), &he) {
+			assert.Equal(t, http.StatusBadRequest, he.Code)
+		}
+	})
+}
+
+func TestSearchModules(t *testing.T) {
+	t.Run("match", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/v1/modules/search?q=DNS", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		h := handler.NewHandler(moduleStorage(), config.S3{})
+
+		if assert.NoError(t, h.SearchModules(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{
+				"meta":{"limit":15,"current_offset":0},
+				"modules":[
+					{"id":"other/dns/aws/2.0.0","namespace":"other","name":"dns","provider":"aws","version":"2.0.0","description":"","owner":"","source":"","downloads":0}
+				]
+			}`, rec.Body.String())
+		}
+	})
+
+	t.Run("missing-query", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/v1/modules/search", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		h := handler.NewHandler(moduleStorage(), config.S3{})
+
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, h.SearchModules(c), &he) {
+			assert.Equal(t, http.StatusBadRequest, he.Code)
+		}
+	})
+}
+
+func TestGetModule(t *testing.T) {
+	t.Run("latest", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/v1/modules/:namespace/:name/:provider")
+		c.SetParamNames("namespace", "name", "provider")
+		c.SetParamValues("acme", "vpc", "aws")
+
+		s := moduleStorage()
+		s.GetBufferFunc = func(key string) ([]byte, error) {
+			if key == "modules/acme/vpc/aws/1.2.0/metadata.json" {
+				return []byte(`{"owner":"platform","description":"Shared VPC","source":"https://git.example.com/vpc","published_at":"2024-05-01T10:00:00Z"}`), nil
+			}
+			return nil, nil
+		}
+		h := handler.NewHandler(s, config.S3{})
+
+		if assert.NoError(t, h.GetModule(c)) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{
+				"id":"acme/vpc/aws/1.2.0","owner":"platform","namespace":"acme","name":"vpc","version":"1.2.0","provider":"aws",
+				"description":"Shared VPC","source":"https://git.example.com/vpc","published_at":"2024-05-01T10:00:00Z",
+				"downloads":0,"versions":["1.0.0","1.2.0"]
+			}`, rec.Body.String())
+		}
+	})
+
+	t.Run("not-found", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/v1/modules/:namespace/:name/:provider")
+		c.SetParamNames("namespace", "name", "provider")
+		c.SetParamValues("acme", "missing", "aws")
+
+		h := handler.NewHandler(moduleStorage(), config.S3{})
+
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, h.GetModule(c), &he) {
+			assert.Equal(t, http.StatusNotFound, he.Code)
+		}
+	})
+}
+
+func TestDownloadLatestModule(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/v1/modules/acme/vpc/aws/download", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/v1/modules/:namespace/:name/:provider/download")
+	c.SetParamNames("namespace", "name", "provider")
+	c.SetParamValues("acme", "vpc", "aws")
+
+	h := handler.NewHandler(moduleStorage(), config.S3{})
+
+	if assert.NoError(t, h.DownloadLatestModule(c)) {
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "/v1/modules/acme/vpc/aws/1.2.0/download", rec.Header().Get(echo.HeaderLocation))
+	}
+}
+
+func TestModuleReadme(t *testing.T) {
+	newContext := func(target string) (echo.Context, *httptest.ResponseRecorder) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("namespace", "name", "provider", "version")
+		c.SetParamValues("acme", "vpc", "aws", "1.2.0")
+		return c, rec
+	}
+
+	s := &storage.MockStorage{
+		GetBufferFunc: func(key string) ([]byte, error) {
+			switch key {
+			case "modules/acme/vpc/aws/1.2.0/docs.json":
+				return []byte(`{"root":{"path":""},"examples":[{"path":"examples/basic","inputs":[],"outputs":[{"name":"id","description":""}]}]}`), nil
+			case "modules/acme/vpc/aws/1.2.0/README.md":
+				return []byte("# VPC\n<script>x</script>"), nil
+			}
+			return nil, nil
+		},
+	}
+	h := handler.NewHandler(s, config.S3{})
+
+	t.Run("markdown", func(t *testing.T) {
+		c, rec := newContext("/")
+		if assert.NoError(t, h.ModuleReadme(c)) {
+			assert.Equal(t, "text/markdown; charset=UTF-8", rec.Header().Get(echo.HeaderContentType))
+			assert.Equal(t, "# VPC\n<script>x</script>", rec.Body.String())
+		}
+	})
+
+	t.Run("html", func(t *testing.T) {
+		c, rec := newContext("/")
+		if assert.NoError(t, h.ModuleReadmeHTML(c)) {
+			assert.Contains(t, rec.Body.String(), `<h1 id="vpc">VPC</h1>`)
+			assert.NotContains(t, rec.Body.String(), "<script>")
+		}
+	})
+
+	t.Run("unknown-path", func(t *testing.T) {
+		c, _ := newContext("/?path=../../secrets")
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, h.ModuleReadme(c), &he) {
+			assert.Equal(t, http.StatusNotFound, he.Code)
+		}
+	})
+
+	t.Run("examples", func(t *testing.T) {
+		c, rec := newContext("/")
+		if assert.NoError(t, h.ListModuleExamples(c)) {
+			assert.JSONEq(t, `{"examples":[{"path":"examples/basic","empty":false,"inputs":[],"outputs":[{"name":"id","description":""}],"dependencies":null,"provider_dependencies":null,"resources":null}]}`, rec.Body.String())
+		}
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	t.Run("publish-invalid-version", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("zip"))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("namespace", "name", "provider", "version")
+		c.SetParamValues("acme", "vpc", "aws", "latest")
+
+		h := handler.NewHandler(&storage.MockStorage{}, config.S3{})
+
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, h.PublishModule(c), &he) {
+			assert.Equal(t, http.StatusBadRequest, he.Code)
+		}
+	})
+
+	t.Run("delete-missing", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodDelete, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("namespace", "type", "version")
+		c.SetParamValues("acme", "widget", "1.0.0")
+
+		h := handler.NewHandler(&storage.MockStorage{}, config.S3{})
+
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, h.DeleteProviderVersion(c), &he) {
+			assert.Equal(t, http.StatusNotFound, he.Code)
+		}
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		h := handler.NewHandler(moduleStorage(), config.S3{})
+		h.Registry.Versions = registry.NewVersionCache(time.Minute)
+		_, err := h.Registry.ListModuleVersions("acme", "vpc", "aws")
+		assert.NoError(t, err)
+
+		if assert.NoError(t, h.FlushCache(c)) {
+			assert.JSONEq(t, `{"flushed":1}`, rec.Body.String())
+		}
+	})
+}
+
+func counterValue(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if want, ok := labels[label.GetName()]; ok && want != label.GetValue() {
+					continue metrics
+				}
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestDownloadMetrics(t *testing.T) {
+	labels := map[string]string{"namespace": "metrics", "name": "vpc", "provider": "aws", "version": "1.0.0"}
+	download := func(mode, version string) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("namespace", "name", "provider", "version")
+		c.SetParamValues("metrics", "vpc", "aws", version)
+
+		s := &storage.MockStorage{
+			GetPresignedURLFunc: func(key string) (string, error) {
+				return "https://example.com/download", nil
+			},
+			StatFunc: func(key string) (*storage.ObjectInfo, error) {
+				return &storage.ObjectInfo{Size: 12}, nil
+			},
+			GetRangeFunc: func(key string, offset, length int64) (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader("file content"[offset:])), nil
+			},
+		}
+		assert.NoError(t, handler.NewHandler(s, config.S3{DownloadMode: mode}).DownloadModuleVersion(c))
+	}
+
+	download("presigned-url", "1.0.0")
+	download("proxy", "1.0.0")
+	download("proxy", "not-a-version")
+
+	// Requests for artifacts that do not exist are not counted.
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	c.SetParamNames("namespace", "name", "provider", "version")
+	c.SetParamValues("metrics", "vpc", "aws", "9.9.9")
+	err := handler.NewHandler(&storage.MockStorage{}, config.S3{DownloadMode: "presigned-url"}).DownloadModuleVersion(c)
+	var httpErr *echo.HTTPError
+	if assert.ErrorAs(t, err, &httpErr) {
+		assert.Equal(t, http.StatusNotFound, httpErr.Code)
+	}
+	assert.Equal(t, 0.0, counterValue(t, "miso_module_downloads_total", merge(labels, "version", "9.9.9")))
+
+	assert.Equal(t, 1.0, counterValue(t, "miso_module_downloads_total", merge(labels, "mode", "presigned-url")))
+	assert.Equal(t, 1.0, counterValue(t, "miso_module_downloads_total", merge(labels, "mode", "proxy")))
+	assert.Equal(t, 0.0, counterValue(t, "miso_module_downloads_total", merge(labels, "version", "not-a-version")))
+	assert.GreaterOrEqual(t, counterValue(t, "miso_proxied_bytes_total", map[string]string{"kind": "module"}), 12.0)
+}
+
+func merge(labels map[string]string, key, value string) map[string]string {
+	out := map[string]string{key: value}
+	for k, v := range labels {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func TestDownloadStatistics(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	keys, err := moduleStorage().List("modules/")
+	require.NoError(t, err)
+	for _, key := range keys {
+		s.SetObject(key, []byte("zip"))
+	}
+	s.GetPresignedURLFunc = func(key string) (string, error) {
+		return "https://example.com/download", nil
+	}
+
+	h := handler.NewHandler(s, config.S3{})
+	h.Stats = stats.NewStore(s)
+	h.Recorder = stats.NewRecorder(h.Stats, 10, time.Hour, h.Logger)
+
+	e := echo.New()
+	for i := 0; i < 2; i++ {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		c.SetParamNames("namespace", "name", "provider", "version")
+		c.SetParamValues("acme", "vpc", "aws", "1.2.0")
+		handler.SetPrincipal(c, "ci")
+		assert.NoError(t, h.DownloadModuleVersion(c))
+	}
+	// A version that does not exist is not recorded.
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	c.SetParamNames("namespace", "name", "provider", "version")
+	c.SetParamValues("acme", "made-up", "aws", "1.0.0")
+	assert.Error(t, h.DownloadModuleVersion(c))
+	h.Recorder.Close()
+
+	t.Run("unknown", func(t *testing.T) {
+		keys, err := s.List("stats/modules/")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"stats/modules/acme/vpc/aws.json"}, keys)
+	})
+
+	t.Run("summary", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.SetParamNames("namespace", "name", "provider")
+		c.SetParamValues("acme", "vpc", "aws")
+
+		if assert.NoError(t, h.ModuleDownloadsSummary(c)) {
+			assert.JSONEq(t, `{"data":{
+				"type":"module-downloads-summary","id":"acme/vpc/aws",
+				"attributes":{"week":2,"month":2,"year":2,"total":2}
+			}}`, rec.Body.String())
+		}
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/v1/modules/acme", nil), rec)
+		c.SetParamNames("namespace")
+		c.SetParamValues("acme")
+
+		if assert.NoError(t, h.ListModules(c)) {
+			assert.JSONEq(t, `{
+				"meta":{"limit":15,"current_offset":0},
+				"modules":[
+					{"id":"acme/vpc/aws/1.2.0","namespace":"acme","name":"vpc","provider":"aws","version":"1.2.0","description":"","owner":"","source":"","downloads":2},
+					{"id":"acme/vpc/google/0.1.0","namespace":"acme","name":"vpc","provider":"google","version":"0.1.0","description":"","owner":"","source":"","downloads":0}
+				]
+			}`, rec.Body.String())
+		}
+	})
+
+	t.Run("detail", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.SetParamNames("namespace", "name", "provider")
+		c.SetParamValues("acme", "vpc", "aws")
+
+		if assert.NoError(t, h.GetModule(c)) {
+			assert.Contains(t, rec.Body.String(), `"downloads":2`)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		c.SetParamNames("namespace", "name", "provider")
+		c.SetParamValues("acme", "vpc", "aws")
+
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, handler.NewHandler(s, config.S3{}).ModuleDownloadsSummary(c), &he) {
+			assert.Equal(t, http.StatusNotFound, he.Code)
+		}
+	})
+}
+
+func TestAudit(t *testing.T) {
+	log, err := audit.Open(audit.NewFileStore(filepath.Join(t.TempDir(), "audit.jsonl")))
+	require.NoError(t, err)
+
+	s := moduleStorage()
+	h := handler.NewHandler(audit.NewStorage(s, log, slog.Default()), config.S3{})
+	h.Audit = log
+	e := echo.New()
+
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("namespace", "name", "provider", "version")
+	c.SetParamValues("acme", "vpc", "aws", "1.0.0")
+	handler.SetPrincipal(c, "admin")
+	require.NoError(t, h.DeleteModuleVersion(c))
+
+	t.Run("query", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/?actor=admin&from=2000-01-01T00:00:00Z", nil), rec)
+
+		if assert.NoError(t, h.QueryAudit(c)) {
+			var body struct {
+				Events []audit.Event `json:"events"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			require.NotEmpty(t, body.Events)
+			assert.Equal(t, audit.ActionDelete, body.Events[0].Action)
+			assert.Equal(t, "modules/acme/vpc/aws/1.0.0/module.zip", body.Events[0].Target)
+			assert.Equal(t, "10.0.0.1", body.Events[0].SourceIP)
+			assert.NoError(t, audit.Verify(body.Events))
+		}
+	})
+
+	t.Run("other-actor", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/?actor=ci", nil), rec)
+
+		if assert.NoError(t, h.QueryAudit(c)) {
+			assert.JSONEq(t, `{"events":[]}`, rec.Body.String())
+		}
+	})
+
+	t.Run("invalid-time", func(t *testing.T) {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/?from=yesterday", nil), httptest.NewRecorder())
+
+		var he *echo.HTTPError
+		if assert.ErrorAs(t, h.QueryAudit(c), &he) {
+			assert.Equal(t, http.StatusBadRequest, he.Code)
+		}
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	events := make(chan webhook.Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer server.Close()
+
+	h := handler.NewHandler(moduleStorage(), config.S3{})
+	h.Webhooks = webhook.NewDispatcher(config.Webhooks{
+		Hooks:       []config.Webhook{{URL: server.URL, Secret: "s3cret"}},
+		MaxAttempts: 1,
+		Timeout:     time.Second,
+	}, nil, slog.Default())
+	defer h.Webhooks.Close()
+
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodDelete, "/", nil), httptest.NewRecorder())
+	c.SetParamNames("namespace", "name", "provider", "version")
+	c.SetParamValues("acme", "vpc", "aws", "1.0.0")
+	handler.SetPrincipal(c, "admin")
+	require.NoError(t, h.DeleteModuleVersion(c))
+
+	select {
+	case e := <-events:
+		assert.Equal(t, webhook.VersionDeleted, e.Type)
+		assert.Equal(t, "acme", e.Namespace)
+		assert.Equal(t, "aws", e.Provider)
+		assert.Equal(t, "1.0.0", e.Version)
+		assert.Equal(t, "admin", e.Principal)
+	case <-time.After(time.Second):
+		t.Fatal("no webhook delivered")
+	}
+}
+
+func TestYankModuleVersion(t *testing.T) {
+	events := make(chan webhook.Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer server.Close()
+
+	s := storage.NewMemoryStorage()
+	s.SetObject(registry.ModuleArchiveKey("acme", "vpc", "aws", "1.0.0"), []byte("zip"))
+	s.SetObject(registry.ModuleArchiveKey("acme", "vpc", "aws", "1.1.0"), []byte("zip"))
+	h := handler.NewHandler(s, config.S3{})
+	h.Webhooks = webhook.NewDispatcher(config.Webhooks{
+		Hooks:       []config.Webhook{{URL: server.URL, Secret: "s3cret", Events: []string{webhook.VersionYanked}}},
+		MaxAttempts: 1,
+		Timeout:     time.Second,
+	}, nil, slog.Default())
+	defer h.Webhooks.Close()
+
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+	c.SetParamNames("namespace", "name", "provider", "version")
+	c.SetParamValues("acme", "vpc", "aws", "1.1.0")
+	handler.SetPrincipal(c, "admin")
+	require.NoError(t, h.YankModuleVersion(c))
+
+	select {
+	case e := <-events:
+		assert.Equal(t, webhook.VersionYanked, e.Type)
+		assert.Equal(t, "module", e.Kind)
+		assert.Equal(t, "vpc", e.Name)
+		assert.Equal(t, "1.1.0", e.Version)
+		assert.Equal(t, "admin", e.Principal)
+	case <-time.After(time.Second):
+		t.Fatal("no webhook delivered")
+	}
+
+	rec := httptest.NewRecorder()
+	c = echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	c.SetParamNames("namespace", "name", "provider")
+	c.SetParamValues("acme", "vpc", "aws")
+	require.NoError(t, h.ListModuleVersions(c))
+	assert.Contains(t, rec.Body.String(), `"1.0.0"`)
+	assert.NotContains(t, rec.Body.String(), `"1.1.0"`)
+
+	c = echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+	c.SetParamNames("namespace", "name", "provider", "version")
+	c.SetParamValues("acme", "vpc", "aws", "2.0.0")
+	var he *echo.HTTPError
+	if assert.ErrorAs(t, h.YankModuleVersion(c), &he) {
+		assert.Equal(t, http.StatusNotFound, he.Code)
+	}
+}
+
+func TestActiveStreams(t *testing.T) {
+	release := make(chan struct{})
+	s := &storage.MockStorage{
+		StatFunc: func(key string) (*storage.ObjectInfo, error) {
+			return &storage.ObjectInfo{Size: 12}, nil
+		},
+		GetRangeFunc: func(key string, offset, length int64) (io.ReadCloser, error) {
+			<-release
+			return io.NopCloser(strings.NewReader("file content"[offset:])), nil
+		},
+	}
+	h := handler.NewHandler(s, config.S3{DownloadMode: "proxy"})
+	h.MaxStreams = 1
+
+	download := func() (echo.Context, *httptest.ResponseRecorder) {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.SetParamNames("namespace", "name", "provider", "version")
+		c.SetParamValues("acme", "vpc", "aws", "1.0.0")
+		return c, rec
+	}
+
+	c, _ := download()
+	done := make(chan error)
+	go func() {
+		done <- h.DownloadModuleVersion(c)
+	}()
+	require.Eventually(t, func() bool { return h.ActiveStreams() == 1 }, time.Second, time.Millisecond)
+
+	// The cap rejects further streams until the first one is done.
+	c, rec := download()
+	require.NoError(t, h.DownloadModuleVersion(c))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), h.ActiveStreams())
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Zero(t, h.ActiveStreams())
 }
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"strings"
+	"sync"
+
+	"miso/internal/download"
+	"miso/internal/registry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxDownloadSeries caps the distinct label sets of each download counter.
+// Only downloads of existing artifacts are counted, and artifacts beyond the
+// cap are counted under "other", so the metrics cannot grow without bound.
+const maxDownloadSeries = 10000
+
+const otherLabel = "other"
+
+var (
+	moduleDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miso_module_downloads_total",
+		Help: "Module downloads by module, version and download mode.",
+	}, []string{"namespace", "name", "provider", "version", "mode"})
+	providerDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miso_provider_downloads_total",
+		Help: "Provider downloads by provider, version, platform and download mode.",
+	}, []string{"namespace", "type", "version", "os", "arch", "mode"})
+	proxiedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miso_proxied_bytes_total",
+		Help: "Bytes of artifacts streamed through miso by kind.",
+	}, []string{"kind"})
+	activeStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "miso_proxy_streams_active",
+		Help: "Proxied downloads currently streaming.",
+	})
+	presignedURLs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miso_presigned_urls_total",
+		Help: "Download URLs signed by result.",
+	}, []string{"result"})
+
+	moduleSeries   = newSeriesLimit(maxDownloadSeries)
+	providerSeries = newSeriesLimit(maxDownloadSeries)
+)
+
+// seriesLimit admits label sets until max distinct sets have been seen.
+type seriesLimit struct {
+	max int
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func newSeriesLimit(max int) *seriesLimit {
+	return &seriesLimit{max: max, seen: make(map[string]struct{})}
+}
+
+// labels returns values when they are known or there is room for them, and
+// otherwise the same number of "other" labels.
+func (l *seriesLimit) labels(values ...string) []string {
+	key := strings.Join(values, "\x00")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[key]; ok || len(l.seen) < l.max {
+		l.seen[key] = struct{}{}
+		return values
+	}
+
+	other := make([]string, len(values))
+	for i := range other {
+		other[i] = otherLabel
+	}
+	return other
+}
+
+func observeModuleDownload(namespace, name, provider, version string, mode download.Mode) {
+	if !registry.ValidVersion(version) {
+		return
+	}
+	labels := moduleSeries.labels(namespace, name, provider, version)
+	moduleDownloads.WithLabelValues(append(labels, string(mode))...).Inc()
+}
+
+func observeProviderDownload(namespace, typeName, version, os, arch string, mode download.Mode) {
+	if !registry.ValidVersion(version) {
+		return
+	}
+	labels := providerSeries.labels(namespace, typeName, version, os, arch)
+	providerDownloads.WithLabelValues(append(labels, string(mode))...).Inc()
+}
@@ -6,17 +6,60 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// RouteClass groups the routes that share a rate limit.
+type RouteClass string
+
+const (
+	RoutesList     RouteClass = "list"
+	RoutesDownload RouteClass = "download"
+	RoutesPublish  RouteClass = "publish"
+)
+
+func (h *Handler) middleware(class RouteClass) []echo.MiddlewareFunc {
+	return h.Middleware[class]
+}
+
 func (h *Handler) Register(v1 *echo.Group) {
+	list, download := h.middleware(RoutesList), h.middleware(RoutesDownload)
+
 	providers := v1.Group("/providers")
-	providers.GET("/:namespace/:type/versions", h.ListProviderVersions)
-	providers.GET("/:namespace/:type/:version/download/:os/:arch", h.DownloadProviderVersion)
+	providers.GET("/:namespace/:type/versions", h.ListProviderVersions, list...)
+	providers.GET("/:namespace/:type/:version/download/:os/:arch", h.DownloadProviderVersion, download...)
+	providers.HEAD("/:namespace/:type/:version/download/:os/:arch", h.DownloadProviderVersion, download...)
 
 	modules := v1.Group("/modules")
-	modules.GET("/:namespace/:name/:provider/versions", h.ListModuleVersions)
-	modules.GET("/:namespace/:name/:provider/:version/download", h.DownloadModuleVersion)
+	modules.GET("", h.ListModules, list...)
+	modules.GET("/search", h.SearchModules, list...)
+	modules.GET("/:namespace", h.ListModules, list...)
+	modules.GET("/:namespace/:name", h.ListModuleProviders, list...)
+	modules.GET("/:namespace/:name/:provider", h.GetModule, list...)
+	modules.GET("/:namespace/:name/:provider/versions", h.ListModuleVersions, list...)
+	modules.GET("/:namespace/:name/:provider/download", h.DownloadLatestModule, download...)
+	modules.HEAD("/:namespace/:name/:provider/download", h.DownloadLatestModule, download...)
+	modules.GET("/:namespace/:name/:provider/downloads/summary", h.ModuleDownloadsSummary, list...)
+	modules.GET("/:namespace/:name/:provider/:version", h.GetModule, list...)
+	modules.GET("/:namespace/:name/:provider/:version/readme", h.ModuleReadme, list...)
+	modules.GET("/:namespace/:name/:provider/:version/readme/html", h.ModuleReadmeHTML, list...)
+	modules.GET("/:namespace/:name/:provider/:version/examples", h.ListModuleExamples, list...)
+	modules.GET("/:namespace/:name/:provider/:version/download", h.DownloadModuleVersion, download...)
+	modules.HEAD("/:namespace/:name/:provider/:version/download", h.DownloadModuleVersion, download...)
 
 	mirror := v1.Group("/mirror")
 	mirror.GET("", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, true)
-	})
+	}, list...)
+}
+
+// RegisterAdmin registers the write and maintenance routes. The caller is
+// responsible for protecting the group with authentication.
+func (h *Handler) RegisterAdmin(admin *echo.Group) {
+	publish := h.middleware(RoutesPublish)
+
+	admin.PUT("/modules/:namespace/:name/:provider/:version", h.PublishModule, publish...)
+	admin.DELETE("/modules/:namespace/:name/:provider/:version", h.DeleteModuleVersion, publish...)
+	admin.POST("/modules/:namespace/:name/:provider/:version/yank", h.YankModuleVersion, publish...)
+	admin.PUT("/providers/:namespace/:type/:version/:os/:arch", h.PublishProvider, publish...)
+	admin.DELETE("/providers/:namespace/:type/:version", h.DeleteProviderVersion, publish...)
+	admin.POST("/cache/flush", h.FlushCache, publish...)
+	admin.GET("/audit", h.QueryAudit, publish...)
 }
@@ -0,0 +1,121 @@
+// Package health serves the liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	StatusOK           = "ok"
+	StatusFailing      = "failing"
+	StatusShuttingDown = "shutting_down"
+)
+
+// errShuttingDown fails readiness once the server is draining.
+var errShuttingDown = errors.New("server is shutting down")
+
+// CheckFunc reports why a dependency is not ready, or nil when it is.
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the body of the readiness response.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+const defaultTimeout = 5 * time.Second
+
+// Checker runs the readiness checks. Every check gets timeout to complete.
+type Checker struct {
+	timeout      time.Duration
+	checks       []check
+	shuttingDown atomic.Bool
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a readiness check. Checks must be added before serving.
+func (c *Checker) Add(name string, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+// ShutDown makes readiness fail from now on, so that load balancers stop
+// sending traffic before the server stops.
+func (c *Checker) ShutDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Check runs every check concurrently.
+func (c *Checker) Check(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(c.checks))}
+	if c.shuttingDown.Load() {
+		report.Status = StatusShuttingDown
+		report.Checks["shutdown"] = CheckResult{Status: StatusFailing, Error: errShuttingDown.Error()}
+		return report
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, chk := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := CheckResult{Status: StatusOK}
+			if err := chk.fn(ctx); err != nil {
+				result = CheckResult{Status: StatusFailing, Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[chk.name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFailing
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+// Livez reports that the process is running.
+func (c *Checker) Livez(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, map[string]string{"status": StatusOK})
+}
+
+// Readyz runs the readiness checks and responds with 503 unless all of them
+// pass.
+func (c *Checker) Readyz(ctx echo.Context) error {
+	report := c.Check(ctx.Request().Context())
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	return ctx.JSON(code, report)
+}
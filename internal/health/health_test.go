@@ -0,0 +1,83 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"miso/internal/health"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func readyz(t *testing.T, checker *health.Checker) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec)
+	assert.NoError(t, checker.Readyz(c))
+	return rec
+}
+
+func TestReadyz(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		checker := health.NewChecker(time.Second)
+		checker.Add("storage", func(context.Context) error { return nil })
+		checker.Add("config", func(context.Context) error { return nil })
+
+		rec := readyz(t, checker)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"status":"ok","checks":{"storage":{"status":"ok"},"config":{"status":"ok"}}}`, rec.Body.String())
+	})
+
+	t.Run("failing", func(t *testing.T) {
+		checker := health.NewChecker(time.Second)
+		checker.Add("storage", func(context.Context) error { return errors.New("access denied") })
+		checker.Add("config", func(context.Context) error { return nil })
+
+		rec := readyz(t, checker)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.JSONEq(t, `{"status":"failing","checks":{
+			"storage":{"status":"failing","error":"access denied"},
+			"config":{"status":"ok"}
+		}}`, rec.Body.String())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		checker := health.NewChecker(10 * time.Millisecond)
+		checker.Add("storage", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		rec := readyz(t, checker)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "context deadline exceeded")
+	})
+
+	t.Run("shutting-down", func(t *testing.T) {
+		checker := health.NewChecker(time.Second)
+		checker.Add("storage", func(context.Context) error { return nil })
+		checker.ShutDown()
+
+		rec := readyz(t, checker)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.JSONEq(t, `{"status":"shutting_down","checks":{"shutdown":{"status":"failing","error":"server is shutting down"}}}`, rec.Body.String())
+	})
+}
+
+func TestLivez(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	checker.Add("storage", func(context.Context) error { return errors.New("access denied") })
+	checker.ShutDown()
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/livez", nil), rec)
+	if assert.NoError(t, checker.Livez(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+	}
+}
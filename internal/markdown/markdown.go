@@ -0,0 +1,37 @@
+// Package markdown renders module documentation to HTML that is safe to
+// embed in other pages.
+package markdown
+
+import (
+	"bytes"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+)
+
+var (
+	renderer = goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	)
+	policy = newPolicy()
+)
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Keep heading anchors and code block languages used for highlighting.
+	p.AllowAttrs("id").OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("class").Matching(bluemonday.SpaceSeparatedTokens).OnElements("code")
+	return p
+}
+
+// Render converts GitHub flavoured markdown to sanitized HTML.
+func Render(source []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := renderer.Convert(source, &buf); err != nil {
+		return nil, err
+	}
+	return policy.SanitizeBytes(buf.Bytes()), nil
+}
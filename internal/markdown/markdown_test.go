@@ -0,0 +1,21 @@
+package markdown_test
+
+import (
+	"testing"
+
+	"miso/internal/markdown"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	html, err := markdown.Render([]byte("# VPC\n\n<script>alert(1)</script>\n\n[link](javascript:alert(1))\n\n```hcl\nmodule \"vpc\" {}\n```\n"))
+	require.NoError(t, err)
+
+	out := string(html)
+	assert.Contains(t, out, `<h1 id="vpc">VPC</h1>`)
+	assert.Contains(t, out, `<code class="language-hcl">`)
+	assert.NotContains(t, out, "<script>")
+	assert.NotContains(t, out, "javascript:")
+}
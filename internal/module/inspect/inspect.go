@@ -0,0 +1,285 @@
+// Package inspect extracts the interface of a Terraform module from its
+// archive.
+package inspect
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"miso/internal/module"
+
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+)
+
+// File is a documentation file extracted from a module archive. Path is
+// relative to the module root.
+type File struct {
+	Path string
+	Data []byte
+}
+
+// maxFileSize bounds the size of a single extracted file.
+const maxFileSize = 1 << 20
+
+// maxUncompressedSize bounds the unpacked size of an archive. The zip
+// reader fails on entries that unpack to more than their header claims, so
+// summing the headers is enough.
+const maxUncompressedSize = 256 << 20
+
+// Archive parses the HCL of a zipped module and returns its root module,
+// submodules under modules/, examples under examples/ and the providers it
+// requires, together with the README and the files of every submodule and
+// example.
+func Archive(data []byte) (*module.Docs, []File, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("module archive is not a valid zip file: %w", err)
+	}
+	var size uint64
+	for _, f := range zr.File {
+		size += f.UncompressedSize64
+		if size > maxUncompressedSize {
+			return nil, nil, fmt.Errorf("module archive unpacks to more than %d MiB", maxUncompressedSize>>20)
+		}
+	}
+
+	root, err := Root(zr)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs, err := FS(zr, root)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := Files(zr, root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return docs, files, nil
+}
+
+// Root returns the directory holding the root module. Archives created from
+// a repository snapshot often wrap everything in a single top-level
+// directory, which is unwrapped here.
+func Root(fsys fs.FS) (string, error) {
+	if tfconfig.IsModuleDirOnFilesystem(tfconfig.WrapFS(fsys), ".") {
+		return ".", nil
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return entries[0].Name(), nil
+	}
+	return ".", nil
+}
+
+// FS parses the module rooted at dir in fsys.
+func FS(fsys fs.FS, dir string) (*module.Docs, error) {
+	tfs := tfconfig.WrapFS(fsys)
+
+	rootSpec, providers, err := loadSpec(tfs, dir, "")
+	if err != nil {
+		return nil, err
+	}
+	submodules, err := loadSpecs(fsys, dir, "modules")
+	if err != nil {
+		return nil, err
+	}
+	examples, err := loadSpecs(fsys, dir, "examples")
+	if err != nil {
+		return nil, err
+	}
+
+	return &module.Docs{
+		Root:       rootSpec,
+		Submodules: submodules,
+		Examples:   examples,
+		Providers:  providers,
+	}, nil
+}
+
+// Files returns the README of the module and every file below its modules/
+// and examples/ directories.
+func Files(fsys fs.FS, dir string) ([]File, error) {
+	files := []File{}
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.EqualFold(entry.Name(), "README.md") {
+			data, err := readFile(fsys, path.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if data != nil {
+				files = append(files, File{Path: "README.md", Data: data})
+			}
+		}
+	}
+
+	for _, sub := range []string{"modules", "examples"} {
+		err := fs.WalkDir(fsys, path.Join(dir, sub), func(name string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			data, err := readFile(fsys, name)
+			if err != nil || data == nil {
+				return err
+			}
+			rel := strings.TrimPrefix(strings.TrimPrefix(name, dir), "/")
+			files = append(files, File{Path: rel, Data: data})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// readFile reads name, returning nil for files over maxFileSize.
+func readFile(fsys fs.FS, name string) ([]byte, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxFileSize {
+		return nil, nil
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// loadSpecs parses every module directory directly below dir/sub.
+func loadSpecs(fsys fs.FS, dir, sub string) ([]module.Spec, error) {
+	tfs := tfconfig.WrapFS(fsys)
+	specs := []module.Spec{}
+
+	entries, err := fs.ReadDir(fsys, path.Join(dir, sub))
+	if errors.Is(err, fs.ErrNotExist) {
+		return specs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		subdir := path.Join(dir, sub, entry.Name())
+		if !tfconfig.IsModuleDirOnFilesystem(tfs, subdir) {
+			continue
+		}
+		spec, _, err := loadSpec(tfs, subdir, sub+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+func loadSpec(tfs tfconfig.FS, dir, specPath string) (module.Spec, []string, error) {
+	spec := module.Spec{
+		Path:                 specPath,
+		Inputs:               []module.Input{},
+		Outputs:              []module.Output{},
+		Dependencies:         []module.Dependency{},
+		ProviderDependencies: []module.ProviderDependency{},
+		Resources:            []module.Resource{},
+	}
+
+	if !tfconfig.IsModuleDirOnFilesystem(tfs, dir) {
+		spec.Empty = true
+		return spec, []string{}, nil
+	}
+
+	m, diags := tfconfig.LoadModuleFromFilesystem(tfs, dir)
+	if diags.HasErrors() {
+		return spec, nil, fmt.Errorf("could not parse module %q: %w", displayPath(specPath), diags.Err())
+	}
+
+	for _, v := range m.Variables {
+		input := module.Input{
+			Name:        v.Name,
+			Type:        v.Type,
+			Description: v.Description,
+			Required:    v.Required,
+		}
+		if !v.Required {
+			def, err := json.Marshal(v.Default)
+			if err != nil {
+				return spec, nil, err
+			}
+			input.Default = string(def)
+		}
+		spec.Inputs = append(spec.Inputs, input)
+	}
+	sort.Slice(spec.Inputs, func(i, j int) bool { return spec.Inputs[i].Name < spec.Inputs[j].Name })
+
+	for _, o := range m.Outputs {
+		spec.Outputs = append(spec.Outputs, module.Output{Name: o.Name, Description: o.Description})
+	}
+	sort.Slice(spec.Outputs, func(i, j int) bool { return spec.Outputs[i].Name < spec.Outputs[j].Name })
+
+	for _, call := range m.ModuleCalls {
+		spec.Dependencies = append(spec.Dependencies, module.Dependency{Name: call.Name, Source: call.Source, Version: call.Version})
+	}
+	sort.Slice(spec.Dependencies, func(i, j int) bool { return spec.Dependencies[i].Name < spec.Dependencies[j].Name })
+
+	providers := []string{}
+	for name, req := range m.RequiredProviders {
+		dep := module.ProviderDependency{
+			Name:    name,
+			Source:  req.Source,
+			Version: strings.Join(req.VersionConstraints, ", "),
+		}
+		if parts := strings.Split(req.Source, "/"); len(parts) >= 2 {
+			dep.Namespace = parts[len(parts)-2]
+		}
+		spec.ProviderDependencies = append(spec.ProviderDependencies, dep)
+		providers = append(providers, name)
+	}
+	sort.Slice(spec.ProviderDependencies, func(i, j int) bool {
+		return spec.ProviderDependencies[i].Name < spec.ProviderDependencies[j].Name
+	})
+	sort.Strings(providers)
+
+	for _, resources := range []map[string]*tfconfig.Resource{m.ManagedResources, m.DataResources} {
+		for _, r := range resources {
+			spec.Resources = append(spec.Resources, module.Resource{Name: r.Name, Type: r.Type})
+		}
+	}
+	sort.Slice(spec.Resources, func(i, j int) bool {
+		if spec.Resources[i].Type != spec.Resources[j].Type {
+			return spec.Resources[i].Type < spec.Resources[j].Type
+		}
+		return spec.Resources[i].Name < spec.Resources[j].Name
+	})
+
+	return spec, providers, nil
+}
+
+func displayPath(specPath string) string {
+	if specPath == "" {
+		return "root"
+	}
+	return specPath
+}
@@ -0,0 +1,129 @@
+package inspect_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"miso/internal/module"
+	"miso/internal/module/inspect"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestArchive(t *testing.T) {
+	data := zipFiles(t, map[string]string{
+		"vpc-main/main.tf": `
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = ">= 5.0"
+    }
+  }
+}
+
+variable "cidr" {
+  type        = string
+  description = "CIDR block of the VPC"
+}
+
+variable "tags" {
+  type    = map(string)
+  default = { team = "platform" }
+}
+
+resource "aws_vpc" "this" {
+  cidr_block = var.cidr
+}
+
+data "aws_region" "current" {}
+
+module "subnets" {
+  source  = "acme/subnets/aws"
+  version = "1.0.0"
+}
+
+output "vpc_id" {
+  description = "ID of the VPC"
+  value       = aws_vpc.this.id
+}
+`,
+		"vpc-main/modules/endpoint/main.tf": `
+variable "service" {}
+`,
+		"vpc-main/README.md":                  "# VPC",
+		"vpc-main/examples/basic/main.tf":     `output "id" { value = "x" }`,
+		"vpc-main/examples/basic/README.md":   "# Basic",
+		"vpc-main/examples/basic/terraform.d": "",
+	})
+
+	docs, files, err := inspect.Archive(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, []module.Input{
+		{Name: "cidr", Type: "string", Description: "CIDR block of the VPC", Required: true},
+		{Name: "tags", Type: "map(string)", Default: `{"team":"platform"}`},
+	}, docs.Root.Inputs)
+	assert.Equal(t, []module.Output{{Name: "vpc_id", Description: "ID of the VPC"}}, docs.Root.Outputs)
+	assert.Equal(t, []module.Dependency{{Name: "subnets", Source: "acme/subnets/aws", Version: "1.0.0"}}, docs.Root.Dependencies)
+	assert.Equal(t, []module.ProviderDependency{{Name: "aws", Namespace: "hashicorp", Source: "hashicorp/aws", Version: ">= 5.0"}}, docs.Root.ProviderDependencies)
+	assert.Equal(t, []module.Resource{{Name: "current", Type: "aws_region"}, {Name: "this", Type: "aws_vpc"}}, docs.Root.Resources)
+	assert.Equal(t, []string{"aws"}, docs.Providers)
+
+	require.Len(t, docs.Submodules, 1)
+	assert.Equal(t, "modules/endpoint", docs.Submodules[0].Path)
+	assert.Equal(t, []module.Input{{Name: "service", Required: true}}, docs.Submodules[0].Inputs)
+
+	require.Len(t, docs.Examples, 1)
+	assert.Equal(t, "examples/basic", docs.Examples[0].Path)
+	assert.Equal(t, []module.Output{{Name: "id"}}, docs.Examples[0].Outputs)
+
+	paths := map[string]string{}
+	for _, f := range files {
+		paths[f.Path] = string(f.Data)
+	}
+	assert.Equal(t, map[string]string{
+		"README.md":                  "# VPC",
+		"modules/endpoint/main.tf":   "\nvariable \"service\" {}\n",
+		"examples/basic/main.tf":     `output "id" { value = "x" }`,
+		"examples/basic/README.md":   "# Basic",
+		"examples/basic/terraform.d": "",
+	}, paths)
+}
+
+func TestArchiveInvalid(t *testing.T) {
+	_, _, err := inspect.Archive([]byte("not a zip"))
+	assert.Error(t, err)
+
+	_, _, err = inspect.Archive(zipFiles(t, map[string]string{"main.tf": `variable "x" {`}))
+	assert.Error(t, err)
+
+	// A zip bomb is rejected from its headers, before anything is unpacked.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"main.tf", "bomb.tf"} {
+		_, err := zw.CreateRaw(&zip.FileHeader{Name: name, Method: zip.Deflate, UncompressedSize64: 200 << 20})
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	_, _, err = inspect.Archive(buf.Bytes())
+	assert.ErrorContains(t, err, "unpacks to more than 256 MiB")
+}
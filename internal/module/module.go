@@ -1,5 +1,7 @@
 package module
 
+import "time"
+
 type Version struct {
 	Version string `json:"version"`
 }
@@ -8,8 +10,114 @@ type Metadata struct {
 	Versions []Version `json:"versions"`
 }
 
+// VersionMetadata is stored next to each published module archive.
+type VersionMetadata struct {
+	Owner       string    `json:"owner"`
+	Description string    `json:"description"`
+	Source      string    `json:"source"`
+	PublishedAt time.Time `json:"published_at"`
+	// Yanked versions stay downloadable but are left out of listings.
+	Yanked bool `json:"yanked,omitempty"`
+}
+
 type Module struct {
-	Namespace    string
-	Name         string
-	TargetSystem string
+	Namespace    string    `json:"namespace"`
+	Name         string    `json:"name"`
+	TargetSystem string    `json:"provider"`
+	Owner        string    `json:"owner,omitempty"`
+	Description  string    `json:"description,omitempty"`
+	Source       string    `json:"source,omitempty"`
+	PublishedAt  time.Time `json:"published_at,omitzero"`
+	Downloads    int64     `json:"downloads,omitempty"`
+	Versions     []Version `json:"versions,omitempty"`
+}
+
+// ID returns the registry address of the module, e.g. "hashicorp/consul/aws".
+func (m Module) ID() string {
+	return m.Namespace + "/" + m.Name + "/" + m.TargetSystem
+}
+
+// LatestVersion returns the newest version of the module. Versions are kept
+// sorted in ascending order, so this is the last element.
+func (m Module) LatestVersion() string {
+	if len(m.Versions) == 0 {
+		return ""
+	}
+	return m.Versions[len(m.Versions)-1].Version
+}
+
+// SetMetadata copies the metadata of the latest version onto the module.
+func (m *Module) SetMetadata(meta VersionMetadata) {
+	m.Owner = meta.Owner
+	m.Description = meta.Description
+	m.Source = meta.Source
+	m.PublishedAt = meta.PublishedAt
+}
+
+// Summary is the representation of a module in listing and search results.
+type Summary struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	Namespace   string    `json:"namespace"`
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	Provider    string    `json:"provider"`
+	Description string    `json:"description"`
+	Source      string    `json:"source"`
+	PublishedAt time.Time `json:"published_at,omitzero"`
+	Downloads   int64     `json:"downloads"`
+}
+
+func (m Module) Summary() Summary {
+	version := m.LatestVersion()
+	return Summary{
+		ID:          m.ID() + "/" + version,
+		Owner:       m.Owner,
+		Namespace:   m.Namespace,
+		Name:        m.Name,
+		Version:     version,
+		Provider:    m.TargetSystem,
+		Description: m.Description,
+		Source:      m.Source,
+		PublishedAt: m.PublishedAt,
+		Downloads:   m.Downloads,
+	}
+}
+
+// Detail is the representation of a single module version returned by the
+// module detail endpoints.
+type Detail struct {
+	Summary
+	Versions   []string `json:"versions"`
+	Root       *Spec    `json:"root,omitempty"`
+	Submodules []Spec   `json:"submodules,omitempty"`
+	Examples   []Spec   `json:"examples,omitempty"`
+	Providers  []string `json:"providers,omitempty"`
+}
+
+func (m Module) Detail() Detail {
+	versions := make([]string, 0, len(m.Versions))
+	for _, v := range m.Versions {
+		versions = append(versions, v.Version)
+	}
+	return Detail{
+		Summary:  m.Summary(),
+		Versions: versions,
+	}
+}
+
+// Meta describes the page returned by a listing endpoint.
+type Meta struct {
+	Limit         int    `json:"limit"`
+	CurrentOffset int    `json:"current_offset"`
+	NextOffset    *int   `json:"next_offset,omitempty"`
+	PrevOffset    *int   `json:"prev_offset,omitempty"`
+	NextURL       string `json:"next_url,omitempty"`
+	PrevURL       string `json:"prev_url,omitempty"`
+}
+
+// List is the response body of the module listing and search endpoints.
+type List struct {
+	Meta    Meta      `json:"meta"`
+	Modules []Summary `json:"modules"`
 }
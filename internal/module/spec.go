@@ -0,0 +1,74 @@
+package module
+
+// Input is a module variable as shown by the registry.
+type Input struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	// Default is the JSON encoding of the default value, empty when the
+	// input is required.
+	Default  string `json:"default"`
+	Required bool   `json:"required"`
+}
+
+type Output struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Dependency is a module call made by a module.
+type Dependency struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Version string `json:"version"`
+}
+
+// ProviderDependency is a provider listed in required_providers.
+type ProviderDependency struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Source    string `json:"source"`
+	Version   string `json:"version"`
+}
+
+type Resource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Spec describes the interface of the root module or of a submodule, in the
+// shape used by the public registry.
+type Spec struct {
+	Path                 string               `json:"path"`
+	Empty                bool                 `json:"empty"`
+	Inputs               []Input              `json:"inputs"`
+	Outputs              []Output             `json:"outputs"`
+	Dependencies         []Dependency         `json:"dependencies"`
+	ProviderDependencies []ProviderDependency `json:"provider_dependencies"`
+	Resources            []Resource           `json:"resources"`
+}
+
+// Docs is the interface of a module version extracted from its archive on
+// publish and stored next to it.
+type Docs struct {
+	Root       Spec     `json:"root"`
+	Submodules []Spec   `json:"submodules"`
+	Examples   []Spec   `json:"examples"`
+	Providers  []string `json:"providers"`
+}
+
+// Spec returns the root module, submodule or example at path, where the
+// root module has the empty path.
+func (d Docs) Spec(path string) (Spec, bool) {
+	if path == "" {
+		return d.Root, true
+	}
+	for _, specs := range [][]Spec{d.Submodules, d.Examples} {
+		for _, spec := range specs {
+			if spec.Path == path {
+				return spec, true
+			}
+		}
+	}
+	return Spec{}, false
+}
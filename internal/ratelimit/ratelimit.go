@@ -0,0 +1,111 @@
+// Package ratelimit limits how fast each client may call the registry.
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"miso/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+var rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "miso_rate_limit_rejections_total",
+	Help: "Requests rejected with 429 by route class, or stream for the proxied stream cap.",
+}, []string{"class"})
+
+// idleExpiry is how long the limiter of a client that stopped sending
+// requests is kept.
+const idleExpiry = 10 * time.Minute
+
+// Reject responds with 429 in the registry error format, asking the client
+// to retry after the given delay. class labels the rejection metric.
+func Reject(c echo.Context, class string, retryAfter time.Duration) error {
+	rejections.WithLabelValues(class).Inc()
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	return c.JSON(http.StatusTooManyRequests, map[string][]string{
+		"errors": {"too many requests, retry later"},
+	})
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter allows each client a sustained rate of requests with bursts.
+type Limiter struct {
+	class string
+	limit rate.Limit
+	burst int
+	key   func(echo.Context) string
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// New returns a limiter for the routes of class, telling clients apart by
+// key. It returns nil when cfg sets no rate.
+func New(class string, cfg config.Limit, key func(echo.Context) string) *Limiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+	return &Limiter{
+		class:   class,
+		limit:   rate.Limit(cfg.Rate),
+		burst:   max(cfg.Burst, 1),
+		key:     key,
+		clients: make(map[string]*client),
+	}
+}
+
+// reserve takes a token for key and returns how long the client must wait
+// before the request would be allowed, or zero when it is allowed now.
+func (l *Limiter) reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > idleExpiry {
+		for k, c := range l.clients {
+			if now.Sub(c.lastSeen) > idleExpiry {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// Middleware rejects the requests of clients that exceed the limit.
+func (l *Limiter) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if delay := l.reserve(l.key(c), time.Now()); delay > 0 {
+				return Reject(c, l.class, delay)
+			}
+			return next(c)
+		}
+	}
+}
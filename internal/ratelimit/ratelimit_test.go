@@ -0,0 +1,49 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"miso/internal/config"
+	"miso/internal/ratelimit"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	limiter := ratelimit.New("list", config.Limit{Rate: 0.5, Burst: 2}, func(c echo.Context) string {
+		return c.Request().Header.Get("X-Client")
+	})
+	require.NotNil(t, limiter)
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, limiter.Middleware())
+
+	get := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Client", client)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, get("a").Code)
+	assert.Equal(t, http.StatusOK, get("a").Code)
+
+	rec := get("a")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"errors":["too many requests, retry later"]}`, rec.Body.String())
+
+	// Other clients have their own budget.
+	assert.Equal(t, http.StatusOK, get("b").Code)
+}
+
+func TestNoLimit(t *testing.T) {
+	assert.Nil(t, ratelimit.New("list", config.Limit{}, nil))
+}
@@ -0,0 +1,97 @@
+package registry
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	versionCacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miso_version_cache_hits_total",
+		Help: "Version listings served from the in-process cache.",
+	}, []string{"kind"})
+	versionCacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miso_version_cache_misses_total",
+		Help: "Version listings computed from storage.",
+	}, []string{"kind"})
+)
+
+// VersionCache keeps computed version lists in memory for a fixed time.
+// Entries are keyed by storage prefix, i.e. by namespace/type for providers
+// and by namespace/name/provider for modules.
+type VersionCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]versionEntry
+}
+
+type versionEntry struct {
+	versions []string
+	expires  time.Time
+}
+
+func NewVersionCache(ttl time.Duration) *VersionCache {
+	return &VersionCache{
+		ttl:     ttl,
+		entries: make(map[string]versionEntry),
+	}
+}
+
+func (c *VersionCache) get(prefix string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[prefix]
+	if ok && time.Now().After(e.expires) {
+		delete(c.entries, prefix)
+		ok = false
+	}
+	if !ok {
+		versionCacheMisses.WithLabelValues(cacheKind(prefix)).Inc()
+		return nil, false
+	}
+
+	versionCacheHits.WithLabelValues(cacheKind(prefix)).Inc()
+	return slices.Clone(e.versions), true
+}
+
+func (c *VersionCache) set(prefix string, versions []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[prefix] = versionEntry{
+		versions: slices.Clone(versions),
+		expires:  time.Now().Add(c.ttl),
+	}
+}
+
+// Invalidate drops the cached versions for a storage prefix.
+func (c *VersionCache) Invalidate(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, prefix)
+}
+
+// Flush drops every cached entry and returns how many there were.
+func (c *VersionCache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.entries)
+	c.entries = make(map[string]versionEntry)
+	return n
+}
+
+func cacheKind(prefix string) string {
+	if strings.HasPrefix(prefix, providersPrefix) {
+		return "provider"
+	}
+	return "module"
+}
@@ -0,0 +1,304 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"miso/internal/module"
+	"miso/internal/storage"
+)
+
+// Index is a snapshot of every module and provider in the registry. It is
+// stored in the bucket so that listing does not require a full scan.
+type Index struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Modules     []module.Module   `json:"modules"`
+	Providers   []ProviderAddress `json:"providers"`
+}
+
+// ErrIndexConflict is returned when other writers kept changing the index
+// while it was being updated.
+var ErrIndexConflict = errors.New("index was changed by other writers, retry")
+
+// indexAttempts bounds how often an index update is retried after another
+// process changed the index first.
+const indexAttempts = 5
+
+// Reindex rebuilds the index from a full storage scan and writes it back.
+func (r *Registry) Reindex() (*Index, error) {
+	return r.writeIndex(func(*Index) (*Index, error) {
+		return r.scanIndex()
+	})
+}
+
+// scanIndex builds the index from a full storage scan.
+func (r *Registry) scanIndex() (*Index, error) {
+	modules, err := r.ListModules("")
+	if err != nil {
+		return nil, err
+	}
+	providers, err := r.ListProviders("")
+	if err != nil {
+		return nil, err
+	}
+	return &Index{Modules: modules, Providers: providers}, nil
+}
+
+// LoadIndex reads the stored index. It returns nil when no index has been
+// written yet.
+func (r *Registry) LoadIndex() (*Index, error) {
+	data, err := r.Storage.GetBuffer(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+
+	return &index, nil
+}
+
+// IndexInfo returns the attributes of the stored index, or nil when no index
+// has been written yet.
+func (r *Registry) IndexInfo() (*storage.ObjectInfo, error) {
+	return r.Storage.Stat(indexKey)
+}
+
+// Verify checks the stored objects for consistency and returns a list of
+// human readable problems. An empty list means the registry is healthy.
+func (r *Registry) Verify() ([]string, error) {
+	var problems []string
+
+	modules, err := r.ListModules("")
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range modules {
+		keys, err := r.Storage.List(ModulePrefix(m.Namespace, m.Name, m.TargetSystem))
+		if err != nil {
+			return nil, err
+		}
+		present := make(map[string]struct{}, len(keys))
+		for _, key := range keys {
+			present[key] = struct{}{}
+		}
+		for _, v := range m.Versions {
+			if !ValidVersion(v.Version) {
+				problems = append(problems, fmt.Sprintf("module %s: invalid version %q", m.ID(), v.Version))
+				continue
+			}
+			if _, ok := present[ModuleArchiveKey(m.Namespace, m.Name, m.TargetSystem, v.Version)]; !ok {
+				problems = append(problems, fmt.Sprintf("module %s %s: missing module.zip", m.ID(), v.Version))
+			}
+		}
+	}
+
+	providers, err := r.ListProviders("")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range providers {
+		prefix := ProviderPrefix(p.Namespace, p.Type)
+		keys, err := r.Storage.List(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			// <version>/<os>/<arch>/<binary>
+			parts := strings.Split(strings.TrimPrefix(key, prefix), "/")
+			if len(parts) != 4 {
+				problems = append(problems, fmt.Sprintf("provider %s/%s: unexpected object %s", p.Namespace, p.Type, key))
+				continue
+			}
+			if !ValidVersion(parts[0]) {
+				problems = append(problems, fmt.Sprintf("provider %s/%s: invalid version %q", p.Namespace, p.Type, parts[0]))
+				continue
+			}
+			if parts[3] != ProviderBinaryName(p.Type, parts[0]) {
+				problems = append(problems, fmt.Sprintf("provider %s/%s %s: unexpected binary name %s", p.Namespace, p.Type, parts[0], parts[3]))
+			}
+		}
+	}
+
+	index, err := r.LoadIndex()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case index == nil:
+		problems = append(problems, "index has not been generated, run reindex")
+	case !sameModules(index.Modules, modules) || !sameProviders(index.Providers, providers):
+		problems = append(problems, fmt.Sprintf("index generated at %s is stale, run reindex", index.GeneratedAt.Format(time.RFC3339)))
+	}
+
+	return problems, nil
+}
+
+func sameModules(a, b []module.Module) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID() != b[i].ID() || len(a[i].Versions) != len(b[i].Versions) {
+			return false
+		}
+		for j := range a[i].Versions {
+			if a[i].Versions[j] != b[i].Versions[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sameProviders(a, b []ProviderAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Namespace != b[i].Namespace || a[i].Type != b[i].Type || strings.Join(a[i].Versions, ",") != strings.Join(b[i].Versions, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// writeIndex replaces the stored index with the one build returns from the
+// current index, or from nil when none has been written yet. Writers in
+// this process take turns. The write is conditional on the index being
+// unchanged, so that a writer in another process, e.g. the admin CLI next
+// to the server, makes build run again on top of its update instead of
+// being overwritten.
+func (r *Registry) writeIndex(build func(current *Index) (*Index, error)) (*Index, error) {
+	r.indexMu.Lock()
+	defer r.indexMu.Unlock()
+
+	for range indexAttempts {
+		info, err := r.Storage.Stat(indexKey)
+		if err != nil {
+			return nil, err
+		}
+		var current *Index
+		etag := ""
+		if info != nil {
+			etag = info.ETag
+			if current, err = r.LoadIndex(); err != nil {
+				return nil, err
+			}
+		}
+
+		index, err := build(current)
+		if err != nil {
+			return nil, err
+		}
+		index.GeneratedAt = time.Now().UTC()
+		data, err := json.Marshal(index)
+		if err != nil {
+			return nil, err
+		}
+
+		err = storage.PutIf(r.Storage, indexKey, bytes.NewReader(data), etag)
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return index, nil
+	}
+
+	return nil, ErrIndexConflict
+}
+
+// updateIndex applies fn to the stored index and writes it back. The first
+// update builds the index from a full scan, which already includes the
+// change.
+func (r *Registry) updateIndex(fn func(index *Index) error) error {
+	_, err := r.writeIndex(func(current *Index) (*Index, error) {
+		if current == nil {
+			return r.scanIndex()
+		}
+		return current, fn(current)
+	})
+	return err
+}
+
+// setModule replaces the index entry for m, removing it when m has no
+// versions left.
+func (index *Index) setModule(m module.Module) {
+	index.Modules = slices.DeleteFunc(index.Modules, func(e module.Module) bool { return e.ID() == m.ID() })
+	if len(m.Versions) == 0 {
+		return
+	}
+	index.Modules = append(index.Modules, m)
+	sort.Slice(index.Modules, func(i, j int) bool {
+		return index.Modules[i].ID() < index.Modules[j].ID()
+	})
+}
+
+// setProvider replaces the index entry for p, removing it when p has no
+// versions left.
+func (index *Index) setProvider(p ProviderAddress) {
+	index.Providers = slices.DeleteFunc(index.Providers, func(e ProviderAddress) bool {
+		return e.Namespace == p.Namespace && e.Type == p.Type
+	})
+	if len(p.Versions) == 0 {
+		return
+	}
+	index.Providers = append(index.Providers, p)
+	sort.Slice(index.Providers, func(i, j int) bool {
+		if index.Providers[i].Namespace != index.Providers[j].Namespace {
+			return index.Providers[i].Namespace < index.Providers[j].Namespace
+		}
+		return index.Providers[i].Type < index.Providers[j].Type
+	})
+}
+
+// reindexModule refreshes the index entry of a single module from storage.
+// The versions are listed on every attempt, so that a retry picks up the
+// versions another writer has added meanwhile.
+func (r *Registry) reindexModule(namespace, name, provider string) error {
+	return r.updateIndex(func(index *Index) error {
+		m := module.Module{Namespace: namespace, Name: name, TargetSystem: provider}
+		versions, err := r.scanVersions(ModulePrefix(namespace, name, provider))
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			m.Versions = append(m.Versions, module.Version{Version: v})
+		}
+		if len(versions) > 0 {
+			meta, err := r.ModuleVersionMetadata(namespace, name, provider, m.LatestVersion())
+			if err != nil {
+				return err
+			}
+			m.SetMetadata(meta)
+		}
+		index.setModule(m)
+		return nil
+	})
+}
+
+// reindexProvider refreshes the index entry of a single provider from
+// storage.
+func (r *Registry) reindexProvider(namespace, typeName string) error {
+	return r.updateIndex(func(index *Index) error {
+		versions, err := r.scanVersions(ProviderPrefix(namespace, typeName))
+		if err != nil {
+			return err
+		}
+		index.setProvider(ProviderAddress{Namespace: namespace, Type: typeName, Versions: versions})
+		return nil
+	})
+}
@@ -0,0 +1,41 @@
+package registry
+
+const (
+	modulesPrefix   = "modules/"
+	providersPrefix = "providers/"
+	indexKey        = "index/registry.json"
+)
+
+func ModulePrefix(namespace, name, provider string) string {
+	return modulesPrefix + namespace + "/" + name + "/" + provider + "/"
+}
+
+func ModuleArchiveKey(namespace, name, provider, version string) string {
+	return ModulePrefix(namespace, name, provider) + version + "/module.zip"
+}
+
+func ModuleMetadataKey(namespace, name, provider, version string) string {
+	return ModulePrefix(namespace, name, provider) + version + "/metadata.json"
+}
+
+func ModuleDocsKey(namespace, name, provider, version string) string {
+	return ModulePrefix(namespace, name, provider) + version + "/docs.json"
+}
+
+// ModuleFileKey returns the key of a documentation file extracted from a
+// module archive, with path relative to the module root.
+func ModuleFileKey(namespace, name, provider, version, path string) string {
+	return ModulePrefix(namespace, name, provider) + version + "/" + path
+}
+
+func ProviderPrefix(namespace, typeName string) string {
+	return providersPrefix + namespace + "/" + typeName + "/"
+}
+
+func ProviderBinaryName(typeName, version string) string {
+	return "terraform-provider-" + typeName + "_v" + version
+}
+
+func ProviderBinaryKey(namespace, typeName, version, os, arch string) string {
+	return ProviderPrefix(namespace, typeName) + version + "/" + os + "/" + arch + "/" + ProviderBinaryName(typeName, version)
+}
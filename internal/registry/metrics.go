@@ -0,0 +1,26 @@
+package registry
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var publishTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "miso_publish_total",
+	Help: "Publish attempts by kind (module or provider) and result (success, invalid or error).",
+}, []string{"kind", "result"})
+
+// observePublish counts the outcome of a publish. Invalid requests are kept
+// apart from storage failures.
+func observePublish(kind string, err error) {
+	result := "success"
+	switch {
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrInvalidVersion), errors.Is(err, ErrInvalidArchive):
+		result = "invalid"
+	case err != nil:
+		result = "error"
+	}
+	publishTotal.WithLabelValues(kind, result).Inc()
+}
@@ -0,0 +1,531 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"miso/internal/module"
+	"miso/internal/module/inspect"
+	"miso/internal/storage"
+
+	"golang.org/x/mod/semver"
+)
+
+var (
+	ErrInvalidVersion = errors.New("version is not a valid semantic version")
+	ErrInvalidName    = errors.New("name must not be empty or contain '/'")
+	ErrNotFound       = errors.New("not found")
+	ErrInvalidArchive = errors.New("invalid module archive")
+)
+
+// Registry implements the registry operations shared by the HTTP handlers
+// and the admin CLI on top of a storage backend.
+type Registry struct {
+	Storage storage.Storage
+	// Versions caches version listings when set. Publish and delete
+	// invalidate the affected entries.
+	Versions *VersionCache
+
+	// indexMu serializes index updates, across the copies made by
+	// WithStorage as well.
+	indexMu *sync.Mutex
+}
+
+func New(storage storage.Storage) *Registry {
+	return &Registry{
+		Storage: storage,
+		indexMu: &sync.Mutex{},
+	}
+}
+
+// WithStorage returns a copy of r that reads and writes through s and
+// shares the version cache and index lock of r.
+func (r *Registry) WithStorage(s storage.Storage) *Registry {
+	scoped := *r
+	scoped.Storage = s
+	return &scoped
+}
+
+// ProviderAddress identifies a provider by namespace and type.
+type ProviderAddress struct {
+	Namespace string   `json:"namespace"`
+	Type      string   `json:"type"`
+	Versions  []string `json:"versions,omitempty"`
+}
+
+// ListModuleVersions returns the published versions of a module sorted by
+// semantic version. Yanked versions are left out.
+func (r *Registry) ListModuleVersions(namespace, name, provider string) ([]string, error) {
+	return r.listVersions(ModulePrefix(namespace, name, provider))
+}
+
+// ListProviderVersions returns the published versions of a provider sorted
+// by semantic version.
+func (r *Registry) ListProviderVersions(namespace, typeName string) ([]string, error) {
+	return r.listVersions(ProviderPrefix(namespace, typeName))
+}
+
+func (r *Registry) listVersions(prefix string) ([]string, error) {
+	if r.Versions != nil {
+		if versions, ok := r.Versions.get(prefix); ok {
+			return versions, nil
+		}
+	}
+
+	versions, err := r.scanVersions(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if r.Versions != nil {
+		r.Versions.set(prefix, versions)
+	}
+
+	return versions, nil
+}
+
+// scanVersions lists the versions under prefix from storage, bypassing the
+// version cache. The index is built from it, because the cache may not
+// show a write made a moment ago, by this process or another one.
+func (r *Registry) scanVersions(prefix string) ([]string, error) {
+	keys, err := r.Storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	versionSet := make(map[string]struct{})
+	for _, key := range keys {
+		version := strings.Split(strings.TrimPrefix(key, prefix), "/")[0]
+		versionSet[version] = struct{}{}
+	}
+
+	versions := make([]string, 0, len(versionSet))
+	for version := range versionSet {
+		if strings.HasPrefix(prefix, modulesPrefix) {
+			yanked, err := r.yanked(prefix, version)
+			if err != nil {
+				return nil, err
+			}
+			if yanked {
+				continue
+			}
+		}
+		versions = append(versions, version)
+	}
+	SortVersions(versions)
+
+	return versions, nil
+}
+
+// yanked reports whether the metadata of the module version under prefix
+// marks it as yanked.
+func (r *Registry) yanked(prefix, version string) (bool, error) {
+	meta, err := r.versionMetadata(prefix + version + "/metadata.json")
+	return meta.Yanked, err
+}
+
+// ListModules scans storage for every module, optionally restricted to a
+// namespace. Each module carries its sorted list of versions.
+func (r *Registry) ListModules(namespace string) ([]module.Module, error) {
+	prefix := modulesPrefix
+	if namespace != "" {
+		prefix += namespace + "/"
+	}
+	keys, err := r.Storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	byPrefix := make(map[string]*module.Module)
+	versions := make(map[string]map[string]struct{})
+	for _, key := range keys {
+		// modules/<namespace>/<name>/<provider>/<version>/<file>
+		parts := strings.Split(key, "/")
+		if len(parts) < 6 {
+			continue
+		}
+		m := module.Module{Namespace: parts[1], Name: parts[2], TargetSystem: parts[3]}
+		p := ModulePrefix(m.Namespace, m.Name, m.TargetSystem)
+		if _, ok := byPrefix[p]; !ok {
+			byPrefix[p] = &m
+			versions[p] = make(map[string]struct{})
+		}
+		versions[p][parts[4]] = struct{}{}
+	}
+
+	modules := make([]module.Module, 0, len(byPrefix))
+	for p, m := range byPrefix {
+		for version := range versions[p] {
+			yanked, err := r.yanked(p, version)
+			if err != nil {
+				return nil, err
+			}
+			if !yanked {
+				m.Versions = append(m.Versions, module.Version{Version: version})
+			}
+		}
+		if len(m.Versions) == 0 {
+			continue
+		}
+		sortModuleVersions(m.Versions)
+		meta, err := r.ModuleVersionMetadata(m.Namespace, m.Name, m.TargetSystem, m.LatestVersion())
+		if err != nil {
+			return nil, err
+		}
+		m.SetMetadata(meta)
+		modules = append(modules, *m)
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].ID() < modules[j].ID()
+	})
+
+	return modules, nil
+}
+
+// ListProviders scans storage for every provider, optionally restricted to
+// a namespace.
+func (r *Registry) ListProviders(namespace string) ([]ProviderAddress, error) {
+	prefix := providersPrefix
+	if namespace != "" {
+		prefix += namespace + "/"
+	}
+	keys, err := r.Storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*ProviderAddress)
+	versions := make(map[string]map[string]struct{})
+	for _, key := range keys {
+		// providers/<namespace>/<type>/<version>/...
+		parts := strings.Split(key, "/")
+		if len(parts) < 5 {
+			continue
+		}
+		id := parts[1] + "/" + parts[2]
+		if _, ok := byID[id]; !ok {
+			byID[id] = &ProviderAddress{Namespace: parts[1], Type: parts[2]}
+			versions[id] = make(map[string]struct{})
+		}
+		versions[id][parts[3]] = struct{}{}
+	}
+
+	providers := make([]ProviderAddress, 0, len(byID))
+	for id, addr := range byID {
+		for version := range versions[id] {
+			addr.Versions = append(addr.Versions, version)
+		}
+		SortVersions(addr.Versions)
+		providers = append(providers, *addr)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		if providers[i].Namespace != providers[j].Namespace {
+			return providers[i].Namespace < providers[j].Namespace
+		}
+		return providers[i].Type < providers[j].Type
+	})
+
+	return providers, nil
+}
+
+// PublishModule uploads a module archive for the given version together
+// with its metadata. PublishedAt defaults to the current time.
+func (r *Registry) PublishModule(namespace, name, provider, version string, archive io.Reader, meta module.VersionMetadata) (err error) {
+	defer func() { observePublish("module", err) }()
+
+	if err := validateNames(namespace, name, provider); err != nil {
+		return err
+	}
+	if !ValidVersion(version) {
+		return ErrInvalidVersion
+	}
+	if meta.PublishedAt.IsZero() {
+		meta.PublishedAt = time.Now().UTC()
+	}
+	defer r.invalidate(ModulePrefix(namespace, name, provider))
+
+	data, err := io.ReadAll(archive)
+	if err != nil {
+		return err
+	}
+	docs, files, err := inspect.Archive(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+
+	if err := r.Storage.Put(ModuleArchiveKey(namespace, name, provider, version), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := r.Storage.Put(ModuleFileKey(namespace, name, provider, version, f.Path), bytes.NewReader(f.Data)); err != nil {
+			return err
+		}
+	}
+	if err := r.putJSON(ModuleDocsKey(namespace, name, provider, version), docs); err != nil {
+		return err
+	}
+	if err := r.putJSON(ModuleMetadataKey(namespace, name, provider, version), meta); err != nil {
+		return err
+	}
+
+	return r.reindexModule(namespace, name, provider)
+}
+
+// ModuleVersionMetadata reads the metadata stored for a module version. A
+// version published without metadata yields the zero value.
+func (r *Registry) ModuleVersionMetadata(namespace, name, provider, version string) (module.VersionMetadata, error) {
+	return r.versionMetadata(ModuleMetadataKey(namespace, name, provider, version))
+}
+
+func (r *Registry) versionMetadata(key string) (module.VersionMetadata, error) {
+	var meta module.VersionMetadata
+
+	data, err := r.Storage.GetBuffer(key)
+	if err != nil || data == nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+
+	return meta, err
+}
+
+// ModuleDocs reads the interface extracted from a module version on
+// publish. It returns nil for versions published before extraction existed.
+func (r *Registry) ModuleDocs(namespace, name, provider, version string) (*module.Docs, error) {
+	data, err := r.Storage.GetBuffer(ModuleDocsKey(namespace, name, provider, version))
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var docs module.Docs
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, err
+	}
+
+	return &docs, nil
+}
+
+// ModuleReadme returns the README of the root module, or of the submodule
+// or example at path, as published with a module version.
+func (r *Registry) ModuleReadme(namespace, name, provider, version, path string) ([]byte, error) {
+	docs, err := r.ModuleDocs(namespace, name, provider, version)
+	if err != nil {
+		return nil, err
+	}
+	if docs == nil {
+		return nil, ErrNotFound
+	}
+	if _, ok := docs.Spec(path); !ok {
+		return nil, ErrNotFound
+	}
+
+	key := "README.md"
+	if path != "" {
+		key = path + "/README.md"
+	}
+	data, err := r.Storage.GetBuffer(ModuleFileKey(namespace, name, provider, version, key))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrNotFound
+	}
+
+	return data, nil
+}
+
+// ModuleDetail returns the details of a module version, or of the latest
+// version when version is empty.
+func (r *Registry) ModuleDetail(namespace, name, provider, version string) (module.Detail, error) {
+	versions, err := r.ListModuleVersions(namespace, name, provider)
+	if err != nil {
+		return module.Detail{}, err
+	}
+	if len(versions) == 0 {
+		return module.Detail{}, ErrNotFound
+	}
+	if version == "" {
+		version = versions[len(versions)-1]
+	} else if !slices.Contains(versions, version) {
+		return module.Detail{}, ErrNotFound
+	}
+
+	m := module.Module{Namespace: namespace, Name: name, TargetSystem: provider}
+	for _, v := range versions {
+		m.Versions = append(m.Versions, module.Version{Version: v})
+	}
+	meta, err := r.ModuleVersionMetadata(namespace, name, provider, version)
+	if err != nil {
+		return module.Detail{}, err
+	}
+	m.SetMetadata(meta)
+
+	docs, err := r.ModuleDocs(namespace, name, provider, version)
+	if err != nil {
+		return module.Detail{}, err
+	}
+
+	detail := m.Detail()
+	detail.ID = m.ID() + "/" + version
+	detail.Version = version
+	if docs != nil {
+		detail.Root = &docs.Root
+		detail.Submodules = docs.Submodules
+		detail.Examples = docs.Examples
+		detail.Providers = docs.Providers
+	}
+
+	return detail, nil
+}
+
+func (r *Registry) putJSON(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.Storage.Put(key, bytes.NewReader(data))
+}
+
+// PublishProvider uploads a provider binary for the given version and
+// platform.
+func (r *Registry) PublishProvider(namespace, typeName, version, os, arch string, binary io.Reader) (err error) {
+	defer func() { observePublish("provider", err) }()
+
+	if err := validateNames(namespace, typeName, os, arch); err != nil {
+		return err
+	}
+	if !ValidVersion(version) {
+		return ErrInvalidVersion
+	}
+	defer r.invalidate(ProviderPrefix(namespace, typeName))
+
+	if err := r.Storage.Put(ProviderBinaryKey(namespace, typeName, version, os, arch), binary); err != nil {
+		return err
+	}
+
+	return r.reindexProvider(namespace, typeName)
+}
+
+// DeleteModuleVersion removes every object stored for a module version.
+func (r *Registry) DeleteModuleVersion(namespace, name, provider, version string) error {
+	if err := validateNames(namespace, name, provider, version); err != nil {
+		return err
+	}
+	defer r.invalidate(ModulePrefix(namespace, name, provider))
+
+	if err := r.deletePrefix(ModulePrefix(namespace, name, provider) + version + "/"); err != nil {
+		return err
+	}
+
+	return r.reindexModule(namespace, name, provider)
+}
+
+// YankModuleVersion marks a module version as yanked. The version is left
+// out of listings and no longer resolves as the latest version, but its
+// archive stays in place, so configurations pinned to it keep working.
+func (r *Registry) YankModuleVersion(namespace, name, provider, version string) error {
+	if err := validateNames(namespace, name, provider, version); err != nil {
+		return err
+	}
+	info, err := r.Storage.Stat(ModuleArchiveKey(namespace, name, provider, version))
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return ErrNotFound
+	}
+	defer r.invalidate(ModulePrefix(namespace, name, provider))
+
+	meta, err := r.ModuleVersionMetadata(namespace, name, provider, version)
+	if err != nil {
+		return err
+	}
+	meta.Yanked = true
+	if err := r.putJSON(ModuleMetadataKey(namespace, name, provider, version), meta); err != nil {
+		return err
+	}
+
+	return r.reindexModule(namespace, name, provider)
+}
+
+// DeleteProviderVersion removes every platform binary stored for a provider
+// version.
+func (r *Registry) DeleteProviderVersion(namespace, typeName, version string) error {
+	if err := validateNames(namespace, typeName, version); err != nil {
+		return err
+	}
+	defer r.invalidate(ProviderPrefix(namespace, typeName))
+
+	if err := r.deletePrefix(ProviderPrefix(namespace, typeName) + version + "/"); err != nil {
+		return err
+	}
+
+	return r.reindexProvider(namespace, typeName)
+}
+
+func (r *Registry) invalidate(prefix string) {
+	if r.Versions != nil {
+		r.Versions.Invalidate(prefix)
+	}
+}
+
+func (r *Registry) deletePrefix(prefix string) error {
+	keys, err := r.Storage.List(prefix)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return ErrNotFound
+	}
+
+	for _, key := range keys {
+		if err := r.Storage.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateNames(names ...string) error {
+	for _, name := range names {
+		if name == "" || strings.Contains(name, "/") {
+			return ErrInvalidName
+		}
+	}
+	return nil
+}
+
+// ValidVersion reports whether version is a semantic version without the
+// leading "v" used by the registry protocol.
+func ValidVersion(version string) bool {
+	return !strings.HasPrefix(version, "v") && semver.IsValid("v"+version)
+}
+
+// SortVersions sorts versions in ascending semantic version order. Versions
+// that are not valid semantic versions sort before valid ones.
+func SortVersions(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+}
+
+func sortModuleVersions(versions []module.Version) {
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i].Version, versions[j].Version) < 0
+	})
+}
+
+func compareVersions(a, b string) int {
+	if c := semver.Compare("v"+a, "v"+b); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,241 @@
+package registry_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"miso/internal/module"
+	"miso/internal/registry"
+	"miso/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func moduleArchive(t *testing.T, mainTF string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("main.tf")
+	require.NoError(t, err)
+	_, err = w.Write([]byte(mainTF))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestListModuleVersionsSorted(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	for _, v := range []string{"1.10.0", "1.2.0", "1.2.0-rc.1", "0.9.1"} {
+		s.SetObject(registry.ModuleArchiveKey("acme", "vpc", "aws", v), nil)
+	}
+
+	versions, err := registry.New(s).ListModuleVersions("acme", "vpc", "aws")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0.9.1", "1.2.0-rc.1", "1.2.0", "1.10.0"}, versions)
+}
+
+func TestPublishAndDelete(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	r := registry.New(s)
+
+	_, err := r.Reindex()
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, r.PublishModule("acme", "vpc", "aws", "v1.0.0", bytes.NewReader(nil), module.VersionMetadata{}), registry.ErrInvalidVersion)
+	assert.ErrorIs(t, r.PublishModule("acme", "v/pc", "aws", "1.0.0", bytes.NewReader(nil), module.VersionMetadata{}), registry.ErrInvalidName)
+
+	archive := moduleArchive(t, `variable "cidr" { type = string }`)
+	assert.ErrorIs(t, r.PublishModule("acme", "vpc", "aws", "1.0.0", strings.NewReader("zip"), module.VersionMetadata{}), registry.ErrInvalidArchive)
+	require.NoError(t, r.PublishModule("acme", "vpc", "aws", "1.0.0", bytes.NewReader(archive), module.VersionMetadata{Description: "Shared VPC"}))
+	require.NoError(t, r.PublishProvider("acme", "widget", "0.1.0", "linux", "amd64", strings.NewReader("bin")))
+	stored, _ := s.Object("modules/acme/vpc/aws/1.0.0/module.zip")
+	assert.Equal(t, archive, stored)
+	stored, _ = s.Object("providers/acme/widget/0.1.0/linux/amd64/terraform-provider-widget_v0.1.0")
+	assert.Equal(t, []byte("bin"), stored)
+
+	index, err := r.LoadIndex()
+	require.NoError(t, err)
+	require.Len(t, index.Modules, 1)
+	assert.Equal(t, "acme/vpc/aws", index.Modules[0].ID())
+	assert.Equal(t, "Shared VPC", index.Modules[0].Description)
+	assert.False(t, index.Modules[0].PublishedAt.IsZero())
+	require.Len(t, index.Providers, 1)
+	assert.Equal(t, []string{"0.1.0"}, index.Providers[0].Versions)
+
+	detail, err := r.ModuleDetail("acme", "vpc", "aws", "")
+	require.NoError(t, err)
+	require.NotNil(t, detail.Root)
+	assert.Equal(t, []module.Input{{Name: "cidr", Type: "string", Required: true}}, detail.Root.Inputs)
+
+	problems, err := r.Verify()
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	require.NoError(t, r.DeleteModuleVersion("acme", "vpc", "aws", "1.0.0"))
+	assert.ErrorIs(t, r.DeleteModuleVersion("acme", "vpc", "aws", "1.0.0"), registry.ErrNotFound)
+
+	index, err = r.LoadIndex()
+	require.NoError(t, err)
+	assert.Empty(t, index.Modules)
+}
+
+func TestYankModuleVersion(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	r := registry.New(s)
+	r.Versions = registry.NewVersionCache(time.Minute)
+
+	archive := moduleArchive(t, `variable "cidr" { type = string }`)
+	require.NoError(t, r.PublishModule("acme", "vpc", "aws", "1.0.0", bytes.NewReader(archive), module.VersionMetadata{}))
+	require.NoError(t, r.PublishModule("acme", "vpc", "aws", "1.1.0", bytes.NewReader(archive), module.VersionMetadata{Description: "Broken"}))
+	_, err := r.ListModuleVersions("acme", "vpc", "aws")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, r.YankModuleVersion("acme", "vpc", "aws", "2.0.0"), registry.ErrNotFound)
+	require.NoError(t, r.YankModuleVersion("acme", "vpc", "aws", "1.1.0"))
+
+	versions, err := r.ListModuleVersions("acme", "vpc", "aws")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0"}, versions)
+	meta, err := r.ModuleVersionMetadata("acme", "vpc", "aws", "1.1.0")
+	require.NoError(t, err)
+	assert.True(t, meta.Yanked)
+	assert.Equal(t, "Broken", meta.Description)
+	_, ok := s.Object(registry.ModuleArchiveKey("acme", "vpc", "aws", "1.1.0"))
+	assert.True(t, ok)
+
+	index, err := r.LoadIndex()
+	require.NoError(t, err)
+	require.Len(t, index.Modules, 1)
+	assert.Equal(t, "1.0.0", index.Modules[0].LatestVersion())
+
+	require.NoError(t, r.YankModuleVersion("acme", "vpc", "aws", "1.0.0"))
+	modules, err := r.ListModules("")
+	require.NoError(t, err)
+	assert.Empty(t, modules)
+}
+
+func TestVersionCache(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	lists := 0
+	list := s.ListFunc
+	s.ListFunc = func(prefix string) ([]string, error) {
+		lists++
+		return list(prefix)
+	}
+	s.SetObject(registry.ProviderBinaryKey("acme", "widget", "0.1.0", "linux", "amd64"), nil)
+
+	r := registry.New(s)
+	r.Versions = registry.NewVersionCache(time.Minute)
+
+	for range 3 {
+		versions, err := r.ListProviderVersions("acme", "widget")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"0.1.0"}, versions)
+	}
+	assert.Equal(t, 1, lists)
+
+	require.NoError(t, r.PublishProvider("acme", "widget", "0.2.0", "linux", "amd64", strings.NewReader("bin")))
+	versions, err := r.ListProviderVersions("acme", "widget")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0.1.0", "0.2.0"}, versions)
+
+	// The index is built from storage, not from the cached listing.
+	index, err := r.LoadIndex()
+	require.NoError(t, err)
+	require.Len(t, index.Providers, 1)
+	assert.Equal(t, []string{"0.1.0", "0.2.0"}, index.Providers[0].Versions)
+
+	versions, err = r.ListProviderVersions("acme", "widget")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0.1.0", "0.2.0"}, versions)
+	require.NoError(t, r.DeleteProviderVersion("acme", "widget", "0.1.0"))
+
+	index, err = r.LoadIndex()
+	require.NoError(t, err)
+	require.Len(t, index.Providers, 1)
+	assert.Equal(t, []string{"0.2.0"}, index.Providers[0].Versions)
+	versions, err = r.ListProviderVersions("acme", "widget")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0.2.0"}, versions)
+
+	assert.Equal(t, 1, r.Versions.Flush())
+}
+
+func TestIndexBuiltOnFirstWrite(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	s.SetObject(registry.ModuleArchiveKey("acme", "vpc", "aws", "1.0.0"), nil)
+	r := registry.New(s)
+
+	index, err := r.LoadIndex()
+	require.NoError(t, err)
+	assert.Nil(t, index)
+
+	require.NoError(t, r.PublishProvider("acme", "widget", "0.1.0", "linux", "amd64", strings.NewReader("bin")))
+	index, err = r.LoadIndex()
+	require.NoError(t, err)
+	require.NotNil(t, index)
+	require.Len(t, index.Modules, 1)
+	assert.Equal(t, "acme/vpc/aws", index.Modules[0].ID())
+	require.Len(t, index.Providers, 1)
+	assert.Equal(t, []string{"0.1.0"}, index.Providers[0].Versions)
+}
+
+func TestIndexConcurrentWriters(t *testing.T) {
+	t.Run("same-process", func(t *testing.T) {
+		r := registry.New(storage.NewMemoryStorage())
+		_, err := r.Reindex()
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, r.PublishProvider("acme", fmt.Sprintf("widget%d", i), "0.1.0", "linux", "amd64", strings.NewReader("bin")))
+			}()
+		}
+		wg.Wait()
+
+		index, err := r.LoadIndex()
+		require.NoError(t, err)
+		assert.Len(t, index.Providers, 10)
+	})
+
+	t.Run("other-process", func(t *testing.T) {
+		s := storage.NewMemoryStorage()
+		cli := registry.New(s)
+		_, err := cli.Reindex()
+		require.NoError(t, err)
+
+		// The CLI publishes between the server reading the index and
+		// writing it back.
+		stat := s.StatFunc
+		interleaved := false
+		s.StatFunc = func(key string) (*storage.ObjectInfo, error) {
+			info, err := stat(key)
+			if key == "index/registry.json" && !interleaved {
+				interleaved = true
+				require.NoError(t, cli.PublishProvider("acme", "dns", "1.0.0", "linux", "amd64", strings.NewReader("bin")))
+			}
+			return info, err
+		}
+
+		server := registry.New(s)
+		require.NoError(t, server.PublishProvider("acme", "widget", "0.1.0", "linux", "amd64", strings.NewReader("bin")))
+		assert.True(t, interleaved)
+
+		index, err := server.LoadIndex()
+		require.NoError(t, err)
+		require.Len(t, index.Providers, 2)
+		assert.Equal(t, "dns", index.Providers[0].Type)
+		assert.Equal(t, "widget", index.Providers[1].Type)
+	})
+}
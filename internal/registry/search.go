@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"strings"
+
+	"miso/internal/module"
+)
+
+// ModuleQuery filters the modules returned by SearchModules. Empty fields
+// match everything.
+type ModuleQuery struct {
+	Namespace string
+	Provider  string
+	// Query is a whitespace separated list of terms that must all appear in
+	// the module namespace, name or description.
+	Query string
+}
+
+// Modules returns every module, served from the index when one has been
+// generated and from a storage scan otherwise. The first publish or delete
+// generates the index.
+func (r *Registry) Modules() ([]module.Module, error) {
+	index, err := r.LoadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		return index.Modules, nil
+	}
+	return r.ListModules("")
+}
+
+// SearchModules returns the modules matching q, sorted by address.
+func (r *Registry) SearchModules(q ModuleQuery) ([]module.Module, error) {
+	modules, err := r.Modules()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := strings.Fields(strings.ToLower(q.Query))
+	matched := []module.Module{}
+	for _, m := range modules {
+		if q.Namespace != "" && m.Namespace != q.Namespace {
+			continue
+		}
+		if q.Provider != "" && m.TargetSystem != q.Provider {
+			continue
+		}
+		if !matchTerms(m, terms) {
+			continue
+		}
+		matched = append(matched, m)
+	}
+
+	return matched, nil
+}
+
+func matchTerms(m module.Module, terms []string) bool {
+	haystack := strings.ToLower(m.Namespace + " " + m.Name + " " + m.Description)
+	for _, term := range terms {
+		if !strings.Contains(haystack, term) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+// Package security sets the CORS and security headers of the registry API,
+// and decides which proxies may report the client address.
+package security
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"miso/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// CORS returns the middleware applying the read policy to every route and
+// the admin policy to the routes under adminPrefix. Install it on the
+// server rather than on a group, so that it answers preflight requests
+// before authentication runs.
+func CORS(cfg config.CORS, adminPrefix string) []echo.MiddlewareFunc {
+	if !cfg.Enabled {
+		return nil
+	}
+	isAdmin := func(c echo.Context) bool {
+		path := c.Request().URL.Path
+		return path == adminPrefix || strings.HasPrefix(path, adminPrefix+"/")
+	}
+
+	var out []echo.MiddlewareFunc
+	if len(cfg.Read.AllowOrigins) > 0 {
+		out = append(out, cors(cfg.Read, isAdmin))
+	}
+	if len(cfg.Admin.AllowOrigins) > 0 {
+		out = append(out, cors(cfg.Admin, func(c echo.Context) bool { return !isAdmin(c) }))
+	}
+	return out
+}
+
+func cors(policy config.CORSPolicy, skipper middleware.Skipper) echo.MiddlewareFunc {
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		Skipper:      skipper,
+		AllowOrigins: policy.AllowOrigins,
+		AllowMethods: policy.AllowMethods,
+		AllowHeaders: policy.AllowHeaders,
+	})
+}
+
+// Headers returns the middleware setting the security headers: nosniff and
+// frame denial on every response, HSTS on HTTPS requests, and the content
+// security policy on HTML responses.
+func Headers(cfg config.Security) echo.MiddlewareFunc {
+	secure := middleware.SecureWithConfig(middleware.SecureConfig{
+		ContentTypeNosniff: "nosniff",
+		XFrameOptions:      "DENY",
+		HSTSMaxAge:         cfg.HSTSMaxAge,
+	})
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return secure(func(c echo.Context) error {
+			if cfg.ContentSecurityPolicy != "" {
+				res := c.Response()
+				res.Before(func() {
+					if strings.HasPrefix(res.Header().Get(echo.HeaderContentType), echo.MIMETextHTML) {
+						res.Header().Set(echo.HeaderContentSecurityPolicy, cfg.ContentSecurityPolicy)
+					}
+				})
+			}
+			return next(c)
+		})
+	}
+}
+
+// IPExtractor returns how to find the client address of a request. It is
+// read from X-Forwarded-For only when the request comes from one of
+// trustedProxies, so that other clients cannot choose the address that
+// download policies and rate limits see.
+func IPExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range trustedProxies {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			options = append(options, echo.TrustIPRange(ipNet))
+		}
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+var corsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// Validate checks the CORS and security settings.
+func Validate(cors config.CORS, sec config.Security) error {
+	var errs []error
+
+	for _, p := range []struct {
+		key    string
+		policy config.CORSPolicy
+	}{{"cors.read", cors.Read}, {"cors.admin", cors.Admin}} {
+		for _, origin := range p.policy.AllowOrigins {
+			if origin == "*" {
+				continue
+			}
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+				errs = append(errs, fmt.Errorf("%s.allow_origins: %q is not * or an origin such as https://example.com", p.key, origin))
+			}
+		}
+		for _, method := range p.policy.AllowMethods {
+			if !slices.Contains(corsMethods, method) {
+				errs = append(errs, fmt.Errorf("%s.allow_methods: unknown method %q", p.key, method))
+			}
+		}
+	}
+	if sec.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("security.hsts_max_age: must not be negative"))
+	}
+	for _, cidr := range sec.TrustedProxies {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("security.trusted_proxies: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
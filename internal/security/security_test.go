@@ -0,0 +1,139 @@
+package security_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"miso/internal/config"
+	"miso/internal/security"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var headers = []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization}
+
+func newServer(cors config.CORS, sec config.Security) *echo.Echo {
+	e := echo.New()
+	e.Use(security.Headers(sec))
+	e.Use(security.CORS(cors, "/v1/admin")...)
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/v1/modules", ok)
+	e.PUT("/v1/admin/modules", ok)
+	e.GET("/page", func(c echo.Context) error { return c.HTML(http.StatusOK, "<p>hi</p>") })
+	return e
+}
+
+func preflight(e *echo.Echo, path, origin, method string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodOptions, path, nil)
+	req.Header.Set(echo.HeaderOrigin, origin)
+	req.Header.Set(echo.HeaderAccessControlRequestMethod, method)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORS(t *testing.T) {
+	cors := config.CORS{
+		Enabled: true,
+		Read:    config.CORSPolicy{AllowOrigins: []string{"*"}, AllowMethods: []string{http.MethodGet, http.MethodHead}, AllowHeaders: headers},
+		Admin:   config.CORSPolicy{AllowMethods: []string{http.MethodPut}, AllowHeaders: headers},
+	}
+
+	t.Run("read", func(t *testing.T) {
+		rec := preflight(newServer(cors, config.Security{}), "/v1/modules", "https://app.example.com", http.MethodGet)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "*", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+		assert.Equal(t, "GET,HEAD", rec.Header().Get(echo.HeaderAccessControlAllowMethods))
+	})
+
+	t.Run("admin-closed", func(t *testing.T) {
+		rec := preflight(newServer(cors, config.Security{}), "/v1/admin/modules", "https://app.example.com", http.MethodPut)
+		assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	})
+
+	t.Run("admin-open", func(t *testing.T) {
+		cors := cors
+		cors.Admin.AllowOrigins = []string{"https://admin.example.com"}
+		e := newServer(cors, config.Security{})
+
+		rec := preflight(e, "/v1/admin/modules", "https://admin.example.com", http.MethodPut)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "https://admin.example.com", rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+		assert.Equal(t, "PUT", rec.Header().Get(echo.HeaderAccessControlAllowMethods))
+
+		rec = preflight(e, "/v1/admin/modules", "https://app.example.com", http.MethodPut)
+		assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cors := cors
+		cors.Enabled = false
+		rec := preflight(newServer(cors, config.Security{}), "/v1/modules", "https://app.example.com", http.MethodGet)
+		assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	})
+}
+
+func TestHeaders(t *testing.T) {
+	e := newServer(config.CORS{}, config.Security{HSTSMaxAge: 3600, ContentSecurityPolicy: "default-src 'none'"})
+	get := func(path string, https bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if https {
+			req.Header.Set(echo.HeaderXForwardedProto, "https")
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/v1/modules", false)
+	assert.Equal(t, "nosniff", rec.Header().Get(echo.HeaderXContentTypeOptions))
+	assert.Equal(t, "DENY", rec.Header().Get(echo.HeaderXFrameOptions))
+	assert.Empty(t, rec.Header().Get(echo.HeaderStrictTransportSecurity))
+	assert.Empty(t, rec.Header().Get(echo.HeaderContentSecurityPolicy))
+
+	rec = get("/v1/modules", true)
+	assert.Equal(t, "max-age=3600; includeSubdomains", rec.Header().Get(echo.HeaderStrictTransportSecurity))
+
+	rec = get("/page", false)
+	assert.Equal(t, "default-src 'none'", rec.Header().Get(echo.HeaderContentSecurityPolicy))
+}
+
+func TestIPExtractor(t *testing.T) {
+	request := func(peer string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = peer + ":40000"
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+		return req
+	}
+
+	// Without trusted proxies, a client on the private network cannot
+	// choose its address.
+	direct := security.IPExtractor(nil)
+	assert.Equal(t, "10.1.2.3", direct(request("10.1.2.3")))
+	assert.Equal(t, "127.0.0.1", direct(request("127.0.0.1")))
+
+	proxied := security.IPExtractor([]string{"10.0.0.0/24"})
+	assert.Equal(t, "203.0.113.7", proxied(request("10.0.0.5")))
+	assert.Equal(t, "10.1.2.3", proxied(request("10.1.2.3")))
+	assert.Equal(t, "127.0.0.1", proxied(request("127.0.0.1")))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, security.Validate(config.CORS{
+		Read: config.CORSPolicy{AllowOrigins: []string{"*", "https://example.com", "http://localhost:8080"}, AllowMethods: []string{http.MethodGet}},
+	}, config.Security{}))
+
+	err := security.Validate(config.CORS{
+		Read:  config.CORSPolicy{AllowOrigins: []string{"example.com"}},
+		Admin: config.CORSPolicy{AllowOrigins: []string{"https://example.com/admin"}, AllowMethods: []string{"FETCH"}},
+	}, config.Security{HSTSMaxAge: -1, TrustedProxies: []string{"10.0.0.1"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `cors.read.allow_origins: "example.com"`)
+		assert.Contains(t, err.Error(), `cors.admin.allow_origins: "https://example.com/admin"`)
+		assert.Contains(t, err.Error(), `cors.admin.allow_methods: unknown method "FETCH"`)
+		assert.Contains(t, err.Error(), "security.hsts_max_age")
+		assert.Contains(t, err.Error(), "security.trusted_proxies")
+	}
+}
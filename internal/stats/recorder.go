@@ -0,0 +1,130 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	eventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "miso_download_events_dropped_total",
+		Help: "Download events dropped because the recorder buffer was full.",
+	})
+	batchesFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "miso_download_event_batches_failed_total",
+		Help: "Batches of download events the sink failed to write.",
+	})
+)
+
+const defaultInterval = 10 * time.Second
+
+// Recorder collects download events in memory and writes them to a sink in
+// batches, so that downloads never wait for the sink.
+type Recorder struct {
+	sink      Sink
+	batchSize int
+	interval  time.Duration
+	logger    *slog.Logger
+
+	events chan Event
+	done   chan struct{}
+
+	// mu guards closed, so that no event is queued once events is closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewRecorder starts a recorder that writes a batch whenever batchSize
+// events are pending or interval has passed since the last write.
+func NewRecorder(sink Sink, batchSize int, interval time.Duration, logger *slog.Logger) *Recorder {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	r := &Recorder{
+		sink:      sink,
+		batchSize: batchSize,
+		interval:  interval,
+		logger:    logger,
+		events:    make(chan Event, batchSize*10),
+		done:      make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record queues e. It drops the event instead of blocking when the buffer
+// is full, and once the recorder is closed, e.g. for a proxied download
+// that outlived the shutdown timeout.
+func (r *Recorder) Record(e Event) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	select {
+	case r.events <- e:
+	default:
+		eventsDropped.Inc()
+	}
+}
+
+// Close writes the pending events and stops the recorder.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+	r.mu.Unlock()
+	<-r.done
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, r.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.sink.Write(batch); err != nil {
+			batchesFailed.Inc()
+			r.logger.LogAttrs(context.Background(), slog.LevelError, "could not write download events",
+				slog.Int("events", len(batch)),
+				slog.String("err", err.Error()),
+			)
+		}
+		batch = make([]Event, 0, r.batchSize)
+	}
+
+	for {
+		select {
+		case e, ok := <-r.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= r.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
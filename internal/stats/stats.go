@@ -0,0 +1,107 @@
+// Package stats records artifact downloads and aggregates them into
+// download counts.
+package stats
+
+import (
+	"time"
+)
+
+const (
+	KindModule   = "module"
+	KindProvider = "provider"
+)
+
+// Event is a single module or provider download.
+type Event struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	// Name is the module name or the provider type.
+	Name string `json:"name"`
+	// Provider is the target system of a module. It is empty for providers.
+	Provider  string    `json:"provider,omitempty"`
+	Version   string    `json:"version"`
+	OS        string    `json:"os,omitempty"`
+	Arch      string    `json:"arch,omitempty"`
+	Mode      string    `json:"mode"`
+	Principal string    `json:"principal,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ID returns the registry address of the downloaded artifact, without the
+// version.
+func (e Event) ID() string {
+	if e.Kind == KindModule {
+		return e.Namespace + "/" + e.Name + "/" + e.Provider
+	}
+	return e.Namespace + "/" + e.Name
+}
+
+// Sink stores batches of download events.
+type Sink interface {
+	Write(events []Event) error
+}
+
+// Summary is the number of downloads of an artifact over trailing windows
+// ending today.
+type Summary struct {
+	Week  int64 `json:"week"`
+	Month int64 `json:"month"`
+	Year  int64 `json:"year"`
+	Total int64 `json:"total"`
+}
+
+// Counts is the stored aggregate of an artifact's downloads: the total and
+// the count per UTC day for the last year.
+type Counts struct {
+	Total int64            `json:"total"`
+	Days  map[string]int64 `json:"days"`
+}
+
+const (
+	dayFormat = "2006-01-02"
+	// keepDays is how long daily counts are kept, enough for the yearly
+	// window.
+	keepDays = 366
+)
+
+func (c *Counts) add(t time.Time, n int64) {
+	if c.Days == nil {
+		c.Days = make(map[string]int64)
+	}
+	c.Total += n
+	c.Days[t.UTC().Format(dayFormat)] += n
+}
+
+// prune drops the daily counts that fall out of the yearly window.
+func (c *Counts) prune(now time.Time) {
+	oldest := now.UTC().AddDate(0, 0, -keepDays).Format(dayFormat)
+	for day := range c.Days {
+		if day < oldest {
+			delete(c.Days, day)
+		}
+	}
+}
+
+// Summary sums the daily counts over the last 7, 30 and 365 days,
+// including today.
+func (c Counts) Summary(now time.Time) Summary {
+	today := now.UTC()
+	since := func(days int) string {
+		return today.AddDate(0, 0, -(days - 1)).Format(dayFormat)
+	}
+	week, month, year := since(7), since(30), since(365)
+
+	s := Summary{Total: c.Total}
+	for day, n := range c.Days {
+		if day >= year {
+			s.Year += n
+		}
+		if day >= month {
+			s.Month += n
+		}
+		if day >= week {
+			s.Week += n
+		}
+	}
+	return s
+}
@@ -0,0 +1,129 @@
+package stats_test
+
+import (
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"miso/internal/stats"
+	"miso/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	now := time.Now().UTC()
+	s := storage.NewMemoryStorage()
+	store := stats.NewStore(s)
+
+	var events []stats.Event
+	for _, age := range []int{0, 6, 7, 29, 30, 364, 365} {
+		events = append(events, stats.Event{
+			Kind:      stats.KindModule,
+			Namespace: "acme",
+			Name:      "vpc",
+			Provider:  "aws",
+			Version:   "1.0.0",
+			Time:      now.AddDate(0, 0, -age),
+		})
+	}
+	require.NoError(t, store.Write(events))
+
+	counts, err := store.ModuleCounts("acme", "vpc", "aws")
+	require.NoError(t, err)
+	assert.Equal(t, stats.Summary{Week: 2, Month: 4, Year: 6, Total: 7}, counts.Summary(now))
+}
+
+func TestStoreWrite(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	store := stats.NewStore(s)
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	require.NoError(t, store.Write([]stats.Event{
+		{Kind: stats.KindModule, Namespace: "acme", Name: "vpc", Provider: "aws", Version: "1.0.0", Time: at},
+		{Kind: stats.KindProvider, Namespace: "acme", Name: "dns", Version: "2.0.0", OS: "linux", Arch: "amd64", Time: at},
+	}))
+	require.NoError(t, store.Write([]stats.Event{
+		{Kind: stats.KindModule, Namespace: "acme", Name: "vpc", Provider: "aws", Version: "1.1.0", Time: at.Add(time.Hour)},
+	}))
+
+	counts, err := store.ModuleCounts("acme", "vpc", "aws")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counts.Total)
+
+	counts, err = store.ProviderCounts("acme", "dns")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counts.Total)
+
+	counts, err = store.ModuleCounts("acme", "missing", "aws")
+	require.NoError(t, err)
+	assert.Zero(t, counts.Total)
+
+	files, err := s.List("stats/events/2024/05/01/")
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestModuleTotals(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	store := stats.NewStore(s)
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	// Counts written before the totals file existed are picked up.
+	s.SetObject("stats/modules/acme/dns/google.json", []byte(`{"total":5,"days":{}}`))
+	totals, err := store.ModuleTotals()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"acme/dns/google": 5}, totals)
+
+	require.NoError(t, store.Write([]stats.Event{
+		{Kind: stats.KindModule, Namespace: "acme", Name: "vpc", Provider: "aws", Version: "1.0.0", Time: at},
+		{Kind: stats.KindModule, Namespace: "acme", Name: "vpc", Provider: "aws", Version: "1.1.0", Time: at},
+		{Kind: stats.KindProvider, Namespace: "acme", Name: "dns", Version: "2.0.0", Time: at},
+	}))
+
+	var reads int
+	s.GetBufferFunc = func(key string) ([]byte, error) {
+		reads++
+		data, _ := s.Object(key)
+		return data, nil
+	}
+	totals, err = store.ModuleTotals()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"acme/dns/google": 5, "acme/vpc/aws": 2}, totals)
+	assert.Equal(t, 1, reads)
+}
+
+type sinkFunc func([]stats.Event) error
+
+func (f sinkFunc) Write(events []stats.Event) error { return f(events) }
+
+func TestRecorder(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]stats.Event
+	sink := sinkFunc(func(events []stats.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, events)
+		return nil
+	})
+
+	r := stats.NewRecorder(sink, 2, time.Hour, slog.Default())
+	for i := 0; i < 3; i++ {
+		r.Record(stats.Event{Kind: stats.KindModule, Namespace: "acme", Name: "vpc", Provider: "aws"})
+	}
+	r.Close()
+
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 1)
+	assert.False(t, batches[1][0].Time.IsZero())
+
+	// Downloads still in flight after shutdown are dropped.
+	assert.NotPanics(t, func() {
+		r.Record(stats.Event{Kind: stats.KindModule, Namespace: "acme", Name: "vpc", Provider: "aws"})
+		r.Close()
+	})
+	assert.Len(t, batches, 2)
+}
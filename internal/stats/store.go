@@ -0,0 +1,167 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"miso/internal/storage"
+)
+
+const (
+	eventsPrefix    = "stats/events/"
+	modulesPrefix   = "stats/modules/"
+	providersPrefix = "stats/providers/"
+	// moduleTotalsKey holds the total download count of every module, so
+	// that listings read one object rather than one counts file per module.
+	moduleTotalsKey = "stats/modules.json"
+)
+
+func moduleCountsKey(namespace, name, provider string) string {
+	return modulesPrefix + namespace + "/" + name + "/" + provider + ".json"
+}
+
+func providerCountsKey(namespace, typeName string) string {
+	return providersPrefix + namespace + "/" + typeName + ".json"
+}
+
+// Store is a Sink that keeps download events in the bucket. Every batch is
+// written as a JSON lines file under stats/events/ for offline analysis,
+// and folded into one counts file per artifact for the summary endpoints.
+// The module totals shown in listings are also kept together in one file.
+//
+// Counts are updated with a read-modify-write, so concurrent writers from
+// several miso instances can lose increments. Run a single writer when
+// exact counts matter.
+type Store struct {
+	Storage storage.Storage
+
+	// mu serialises the read-modify-write of counts files.
+	mu sync.Mutex
+}
+
+func NewStore(storage storage.Storage) *Store {
+	return &Store{Storage: storage}
+}
+
+func (s *Store) Write(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	first := events[0].Time.UTC()
+	key := eventsPrefix + first.Format("2006/01/02/") + strconv.FormatInt(first.UnixNano(), 10) + ".jsonl"
+	if err := s.Storage.Put(key, &buf); err != nil {
+		return err
+	}
+
+	byKey := make(map[string][]Event)
+	for _, e := range events {
+		key := providerCountsKey(e.Namespace, e.Name)
+		if e.Kind == KindModule {
+			key = moduleCountsKey(e.Namespace, e.Name, e.Provider)
+		}
+		byKey[key] = append(byKey[key], e)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals, err := s.moduleTotals()
+	if err != nil {
+		return err
+	}
+	for key, events := range byKey {
+		counts, err := s.counts(key)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			counts.add(e.Time, 1)
+		}
+		counts.prune(time.Now())
+		if e := events[0]; e.Kind == KindModule {
+			totals[e.ID()] = counts.Total
+		}
+
+		data, err := json.Marshal(counts)
+		if err != nil {
+			return err
+		}
+		if err := s.Storage.Put(key, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(totals)
+	if err != nil {
+		return err
+	}
+	return s.Storage.Put(moduleTotalsKey, bytes.NewReader(data))
+}
+
+// moduleTotals reads the module totals, building them from the counts
+// files when they were not written yet.
+func (s *Store) moduleTotals() (map[string]int64, error) {
+	totals := make(map[string]int64)
+
+	data, err := s.Storage.GetBuffer(moduleTotalsKey)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		return totals, json.Unmarshal(data, &totals)
+	}
+
+	keys, err := s.Storage.List(modulesPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		counts, err := s.counts(key)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(key, modulesPrefix), ".json")
+		totals[id] = counts.Total
+	}
+	return totals, nil
+}
+
+func (s *Store) counts(key string) (Counts, error) {
+	var counts Counts
+
+	data, err := s.Storage.GetBuffer(key)
+	if err != nil || data == nil {
+		return counts, err
+	}
+	err = json.Unmarshal(data, &counts)
+
+	return counts, err
+}
+
+// ModuleCounts returns the download counts of a module. A module that was
+// never downloaded has zero counts.
+func (s *Store) ModuleCounts(namespace, name, provider string) (Counts, error) {
+	return s.counts(moduleCountsKey(namespace, name, provider))
+}
+
+// ModuleTotals returns the total download count of every downloaded module,
+// by module ID such as hashicorp/consul/aws.
+func (s *Store) ModuleTotals() (map[string]int64, error) {
+	return s.moduleTotals()
+}
+
+// ProviderCounts returns the download counts of a provider.
+func (s *Store) ProviderCounts(namespace, typeName string) (Counts, error) {
+	return s.counts(providerCountsKey(namespace, typeName))
+}
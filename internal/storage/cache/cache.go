@@ -0,0 +1,304 @@
+// Package cache implements a read-through disk cache in front of another
+// storage backend.
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"miso/internal/storage"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Storage caches object content on local disk. Files are addressed by the
+// object key and ETag, so an overwritten object is fetched again instead of
+// being served stale. GetRange asks the backend for the current ETag on
+// every read; GetRangeOf trusts the ETag the caller already got from Stat.
+type Storage struct {
+	storage.Storage
+
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	byKey   map[string]string
+	size    int64
+
+	group singleflight.Group
+}
+
+type entry struct {
+	name string
+	key  string
+	size int64
+}
+
+func New(backend storage.Storage, dir string, maxSize int64) (*Storage, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	s := &Storage{
+		Storage: backend,
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		byKey:   make(map[string]string),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load registers the files left by a previous run, oldest first. Their keys
+// are unknown until they are read again, but they count towards the size
+// limit and are evicted in order.
+func (s *Storage) load() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var infos []fs.FileInfo
+	for _, f := range files {
+		if !f.Type().IsRegular() {
+			continue
+		}
+		if filepath.Ext(f.Name()) == ".tmp" {
+			_ = os.Remove(filepath.Join(s.dir, f.Name()))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return err
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, info := range infos {
+		s.entries[info.Name()] = s.lru.PushFront(&entry{name: info.Name(), size: info.Size()})
+		s.size += info.Size()
+	}
+	s.evict()
+
+	return nil
+}
+
+func (s *Storage) GetStream(key string) (io.ReadCloser, error) {
+	return s.GetRange(key, 0, -1)
+}
+
+func (s *Storage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	info, err := s.Storage.Stat(key)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return s.GetRangeOf(key, info, offset, length)
+}
+
+// GetRangeOf serves a range of key from the cached copy of the version
+// described by info, fetching it first when it is not cached.
+func (s *Storage) GetRangeOf(key string, info *storage.ObjectInfo, offset, length int64) (io.ReadCloser, error) {
+	if info == nil || info.ETag == "" || info.Size > s.maxSize {
+		return s.Storage.GetRange(key, offset, length)
+	}
+
+	name := fileName(key, info.ETag)
+	f, err := s.open(name)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		_, err, _ = s.group.Do(name, func() (interface{}, error) {
+			return nil, s.fetch(key, name, info.ETag)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if f, err = s.open(name); err != nil {
+			return nil, err
+		}
+	}
+	if f == nil {
+		// The object changed while it was being fetched.
+		return s.Storage.GetRange(key, offset, length)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return &limitedFile{Reader: io.LimitReader(f, length), file: f}, nil
+}
+
+// open returns the cached file called name and marks it as recently used,
+// or nil when it is not cached.
+func (s *Storage) open(name string) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[name]
+	if !ok {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		s.remove(elem)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.lru.MoveToFront(elem)
+
+	return f, nil
+}
+
+// fetch downloads key into the cache under name. The download is only kept
+// when the object still has etag afterwards.
+func (s *Storage) fetch(key, name, etag string) error {
+	if s.cached(name) {
+		return nil
+	}
+
+	stream, err := s.Storage.GetStream(key)
+	if err != nil || stream == nil {
+		return err
+	}
+	defer func() { _ = stream.Close() }()
+
+	tmp, err := os.CreateTemp(s.dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	size, err := io.Copy(tmp, stream)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := s.Storage.Stat(key)
+	if err != nil || info == nil || info.ETag != etag {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[name]; ok {
+		s.lru.MoveToFront(elem)
+		return nil
+	}
+	if old, ok := s.byKey[key]; ok && old != name {
+		if elem, ok := s.entries[old]; ok {
+			s.remove(elem)
+		}
+	}
+	s.entries[name] = s.lru.PushFront(&entry{name: name, key: key, size: size})
+	s.byKey[key] = name
+	s.size += size
+	s.evict()
+
+	return nil
+}
+
+func (s *Storage) cached(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entries[name]
+	return ok
+}
+
+func (s *Storage) Put(key string, data io.Reader) error {
+	s.invalidate(key)
+	return s.Storage.Put(key, data)
+}
+
+func (s *Storage) PutIf(key string, data io.Reader, etag string) error {
+	s.invalidate(key)
+	return storage.PutIf(s.Storage, key, data, etag)
+}
+
+func (s *Storage) Delete(key string) error {
+	s.invalidate(key)
+	return s.Storage.Delete(key)
+}
+
+func (s *Storage) invalidate(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name, ok := s.byKey[key]; ok {
+		if elem, ok := s.entries[name]; ok {
+			s.remove(elem)
+		}
+	}
+}
+
+// evict removes the least recently used files until the cache fits its size
+// limit. The caller must hold s.mu.
+func (s *Storage) evict() {
+	for s.size > s.maxSize && s.lru.Len() > 0 {
+		s.remove(s.lru.Back())
+	}
+}
+
+// remove drops a cache entry and its file. Readers that already opened the
+// file keep reading it. The caller must hold s.mu.
+func (s *Storage) remove(elem *list.Element) {
+	e := elem.Value.(*entry)
+	s.lru.Remove(elem)
+	delete(s.entries, e.name)
+	if s.byKey[e.key] == e.name {
+		delete(s.byKey, e.key)
+	}
+	s.size -= e.size
+	_ = os.Remove(filepath.Join(s.dir, e.name))
+}
+
+// Size returns the number of bytes currently cached.
+func (s *Storage) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func fileName(key, etag string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + etag))
+	return hex.EncodeToString(sum[:])
+}
+
+type limitedFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (l *limitedFile) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,158 @@
+package cache_test
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"miso/internal/storage"
+	"miso/internal/storage/cache"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type backend struct {
+	mu      sync.Mutex
+	objects map[string]string
+	etags   map[string]string
+	fetches atomic.Int32
+}
+
+func newBackend() (*backend, *storage.MockStorage) {
+	b := &backend{objects: map[string]string{}, etags: map[string]string{}}
+	return b, &storage.MockStorage{
+		StatFunc: func(key string) (*storage.ObjectInfo, error) {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			data, ok := b.objects[key]
+			if !ok {
+				return nil, nil
+			}
+			return &storage.ObjectInfo{Size: int64(len(data)), ETag: b.etags[key]}, nil
+		},
+		GetStreamFunc: func(key string) (io.ReadCloser, error) {
+			b.fetches.Add(1)
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			return io.NopCloser(strings.NewReader(b.objects[key])), nil
+		},
+	}
+}
+
+func (b *backend) set(key, data, etag string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = data
+	b.etags[key] = etag
+}
+
+func read(t *testing.T, s *cache.Storage, key string, offset, length int64) string {
+	t.Helper()
+	r, err := s.GetRange(key, offset, length)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestReadThrough(t *testing.T) {
+	b, mock := newBackend()
+	b.set("a", "hello world", "1")
+
+	s, err := cache.New(mock, t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello world", read(t, s, "a", 0, -1))
+	assert.Equal(t, "world", read(t, s, "a", 6, -1))
+	assert.Equal(t, "lo", read(t, s, "a", 3, 2))
+	assert.Equal(t, int32(1), b.fetches.Load())
+	assert.Equal(t, int64(11), s.Size())
+
+	b.set("a", "changed", "2")
+	assert.Equal(t, "changed", read(t, s, "a", 0, -1))
+	assert.Equal(t, int32(2), b.fetches.Load())
+	assert.Equal(t, int64(7), s.Size())
+}
+
+func TestGetRangeOf(t *testing.T) {
+	b, mock := newBackend()
+	b.set("a", "hello world", "1")
+	stat := mock.StatFunc
+	var stats atomic.Int32
+	mock.StatFunc = func(key string) (*storage.ObjectInfo, error) {
+		stats.Add(1)
+		return stat(key)
+	}
+
+	s, err := cache.New(mock, t.TempDir(), 1024)
+	require.NoError(t, err)
+	info, err := s.Stat("a")
+	require.NoError(t, err)
+	stats.Store(0)
+
+	// Only the fetch checks that the object did not change meanwhile.
+	for range 2 {
+		r, err := s.GetRangeOf("a", info, 6, -1)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, "world", string(data))
+	}
+	assert.Equal(t, int32(1), b.fetches.Load())
+	assert.Equal(t, int32(1), stats.Load())
+}
+
+func TestSingleflight(t *testing.T) {
+	b, mock := newBackend()
+	b.set("a", strings.Repeat("x", 512), "1")
+
+	s, err := cache.New(mock, t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Len(t, read(t, s, "a", 0, -1), 512)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), b.fetches.Load())
+}
+
+func TestEviction(t *testing.T) {
+	b, mock := newBackend()
+	b.set("a", "aaaa", "1")
+	b.set("b", "bbbb", "1")
+	b.set("c", "cccc", "1")
+
+	dir := t.TempDir()
+	s, err := cache.New(mock, dir, 8)
+	require.NoError(t, err)
+
+	read(t, s, "a", 0, -1)
+	read(t, s, "b", 0, -1)
+	read(t, s, "a", 0, -1)
+	read(t, s, "c", 0, -1)
+	assert.Equal(t, int64(8), s.Size())
+	assert.Equal(t, int32(3), b.fetches.Load())
+
+	// b was least recently used and has been evicted, a is still cached.
+	read(t, s, "a", 0, -1)
+	assert.Equal(t, int32(3), b.fetches.Load())
+	read(t, s, "b", 0, -1)
+	assert.Equal(t, int32(4), b.fetches.Load())
+
+	// A new instance picks up the files left on disk.
+	reopened, err := cache.New(mock, dir, 8)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), reopened.Size())
+}
@@ -0,0 +1,150 @@
+// Package cdn rewrites download URLs onto a CDN or custom domain in front
+// of another storage backend.
+package cdn
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"miso/internal/config"
+	"miso/internal/storage"
+
+	"github.com/aws/aws-sdk-go-v2/feature/cloudfront/sign"
+)
+
+// Storage serves downloads from a custom domain instead of presigned S3
+// URLs. With a CloudFront key pair, URLs are signed with a canned policy.
+type Storage struct {
+	storage.Storage
+
+	domain string
+	expiry atomic.Int64
+	urls   *sign.URLSigner
+}
+
+// New wraps backend so that GetPresignedURL returns URLs on cfg.Domain that
+// stay valid for expiry.
+func New(backend storage.Storage, cfg config.CDN, expiry time.Duration) (*Storage, error) {
+	domain, err := parseDomain(cfg.Domain)
+	if err != nil {
+		return nil, err
+	}
+	s := &Storage{
+		Storage: backend,
+		domain:  domain,
+	}
+	s.SetExpiry(expiry)
+
+	key, err := loadPrivateKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case key == nil && cfg.KeyPairID == "":
+	case key == nil:
+		return nil, errors.New("cdn.key_pair_id: requires cdn.private_key or cdn.private_key_file")
+	case cfg.KeyPairID == "":
+		return nil, errors.New("cdn.private_key: requires cdn.key_pair_id")
+	default:
+		s.urls = sign.NewURLSigner(cfg.KeyPairID, key)
+	}
+
+	return s, nil
+}
+
+// PutIf forwards conditional writes to the backend.
+func (s *Storage) PutIf(key string, data io.Reader, etag string) error {
+	return storage.PutIf(s.Storage, key, data, etag)
+}
+
+// GetRangeOf forwards the metadata the caller already has to the backend.
+func (s *Storage) GetRangeOf(key string, info *storage.ObjectInfo, offset, length int64) (io.ReadCloser, error) {
+	return storage.GetRangeOf(s.Storage, key, info, offset, length)
+}
+
+// SetExpiry changes the lifetime of URLs signed from now on.
+// Zero restores the default.
+func (s *Storage) SetExpiry(expiry time.Duration) {
+	if expiry <= 0 {
+		expiry = config.DefaultPresignExpiry
+	}
+	s.expiry.Store(int64(expiry))
+}
+
+// Validate reports the first problem New would fail on for cfg.
+func Validate(cfg config.CDN) error {
+	_, err := New(nil, cfg, 0)
+	return err
+}
+
+func parseDomain(domain string) (string, error) {
+	u, err := url.Parse(domain)
+	if err != nil {
+		return "", fmt.Errorf("cdn.domain: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("cdn.domain: expected an http or https URL, got %q", domain)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("cdn.domain: must not have a query or fragment, got %q", domain)
+	}
+	return strings.TrimSuffix(domain, "/"), nil
+}
+
+// loadPrivateKey reads the PKCS#1 or PKCS#8 encoded RSA key from the
+// config. It returns nil when no key is configured.
+func loadPrivateKey(cfg config.CDN) (*rsa.PrivateKey, error) {
+	data := []byte(cfg.PrivateKey)
+	switch {
+	case cfg.PrivateKey != "" && cfg.PrivateKeyFile != "":
+		return nil, errors.New("cdn.private_key: conflicts with cdn.private_key_file")
+	case cfg.PrivateKeyFile != "":
+		var err error
+		if data, err = os.ReadFile(cfg.PrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("cdn.private_key_file: %w", err)
+		}
+	case cfg.PrivateKey == "":
+		return nil, nil
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("cdn.private_key: no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cdn.private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("cdn.private_key: expected an RSA key, got %T", parsed)
+	}
+	return key, nil
+}
+
+func (s *Storage) url(key string) string {
+	return s.domain + (&url.URL{Path: "/" + key}).EscapedPath()
+}
+
+func (s *Storage) GetPresignedURL(key string) (string, error) {
+	if len(key) <= 0 {
+		return "", nil
+	}
+	if s.urls == nil {
+		return s.url(key), nil
+	}
+
+	return s.urls.Sign(s.url(key), time.Now().Add(time.Duration(s.expiry.Load())))
+}
@@ -0,0 +1,84 @@
+package cdn_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"miso/internal/config"
+	"miso/internal/storage"
+	"miso/internal/storage/cdn"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func privateKey(t *testing.T) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestUnsignedDomain(t *testing.T) {
+	s, err := cdn.New(&storage.MockStorage{}, config.CDN{Domain: "https://downloads.example.com/"}, 0)
+	require.NoError(t, err)
+
+	u, err := s.GetPresignedURL("modules/ns/my module/aws/1.0.0/module.zip")
+	require.NoError(t, err)
+	assert.Equal(t, "https://downloads.example.com/modules/ns/my%20module/aws/1.0.0/module.zip", u)
+}
+
+func TestSignedURL(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(keyFile, []byte(privateKey(t)), 0o600))
+
+	cfg := config.CDN{
+		Domain:         "https://downloads.example.com",
+		KeyPairID:      "K2JCJMDEHXQW5F",
+		PrivateKeyFile: keyFile,
+	}
+	s, err := cdn.New(&storage.MockStorage{}, cfg, time.Hour)
+	require.NoError(t, err)
+
+	raw, err := s.GetPresignedURL("providers/ns/aws/1.0.0/linux/amd64/terraform-provider-aws_v1.0.0")
+	require.NoError(t, err)
+
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	assert.Equal(t, "downloads.example.com", u.Host)
+	assert.Equal(t, "/providers/ns/aws/1.0.0/linux/amd64/terraform-provider-aws_v1.0.0", u.Path)
+	assert.Equal(t, "K2JCJMDEHXQW5F", u.Query().Get("Key-Pair-Id"))
+	assert.NotEmpty(t, u.Query().Get("Signature"))
+
+	expires, err := strconv.ParseInt(u.Query().Get("Expires"), 10, 64)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), time.Unix(expires, 0), time.Minute)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	key := privateKey(t)
+	tests := map[string]config.CDN{
+		"no domain":      {},
+		"relative":       {Domain: "downloads.example.com"},
+		"key without id": {Domain: "https://d.example.com", PrivateKey: key},
+		"id without key": {Domain: "https://d.example.com", KeyPairID: "K1"},
+		"bad key":        {Domain: "https://d.example.com", KeyPairID: "K1", PrivateKey: "not a key"},
+		"both keys":      {Domain: "https://d.example.com", KeyPairID: "K1", PrivateKey: key, PrivateKeyFile: "key.pem"},
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := cdn.New(&storage.MockStorage{}, cfg, time.Hour)
+			assert.Error(t, err)
+		})
+	}
+}
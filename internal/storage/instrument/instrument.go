@@ -0,0 +1,120 @@
+// Package instrument logs and measures every operation on a storage
+// backend.
+package instrument
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"miso/internal/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "miso_storage_operation_duration_seconds",
+		Help:    "Duration of storage backend calls by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	operationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miso_storage_operation_errors_total",
+		Help: "Failed storage backend calls by method.",
+	}, []string{"method"})
+)
+
+// Storage records the method, key, duration and outcome of each call to
+// the wrapped backend. Successful calls are logged at debug level, failed
+// ones as warnings. Metrics are labelled by method only, never by key.
+type Storage struct {
+	backend storage.Storage
+	logger  *slog.Logger
+}
+
+func New(backend storage.Storage, logger *slog.Logger) *Storage {
+	return &Storage{backend: backend, logger: logger}
+}
+
+func (s *Storage) observe(method, key string, start time.Time, err error) {
+	duration := time.Since(start)
+	operationDuration.WithLabelValues(method).Observe(duration.Seconds())
+
+	level := slog.LevelDebug
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("key", key),
+		slog.Duration("duration", duration),
+	}
+	if err != nil {
+		operationErrors.WithLabelValues(method).Inc()
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("err", err.Error()))
+	}
+	s.logger.LogAttrs(context.Background(), level, "STORAGE", attrs...)
+}
+
+func (s *Storage) GetBuffer(key string) ([]byte, error) {
+	start := time.Now()
+	data, err := s.backend.GetBuffer(key)
+	s.observe("GetBuffer", key, start, err)
+	return data, err
+}
+
+func (s *Storage) GetStream(key string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := s.backend.GetStream(key)
+	s.observe("GetStream", key, start, err)
+	return r, err
+}
+
+func (s *Storage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := s.backend.GetRange(key, offset, length)
+	s.observe("GetRange", key, start, err)
+	return r, err
+}
+
+func (s *Storage) Stat(key string) (*storage.ObjectInfo, error) {
+	start := time.Now()
+	info, err := s.backend.Stat(key)
+	s.observe("Stat", key, start, err)
+	return info, err
+}
+
+func (s *Storage) Put(key string, data io.Reader) error {
+	start := time.Now()
+	err := s.backend.Put(key, data)
+	s.observe("Put", key, start, err)
+	return err
+}
+
+func (s *Storage) PutIf(key string, data io.Reader, etag string) error {
+	start := time.Now()
+	err := storage.PutIf(s.backend, key, data, etag)
+	s.observe("PutIf", key, start, err)
+	return err
+}
+
+func (s *Storage) Delete(key string) error {
+	start := time.Now()
+	err := s.backend.Delete(key)
+	s.observe("Delete", key, start, err)
+	return err
+}
+
+func (s *Storage) List(path string) ([]string, error) {
+	start := time.Now()
+	keys, err := s.backend.List(path)
+	s.observe("List", path, start, err)
+	return keys, err
+}
+
+func (s *Storage) GetPresignedURL(key string) (string, error) {
+	start := time.Now()
+	u, err := s.backend.GetPresignedURL(key)
+	s.observe("GetPresignedURL", key, start, err)
+	return u, err
+}
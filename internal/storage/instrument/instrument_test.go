@@ -0,0 +1,44 @@
+package instrument_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"miso/internal/storage"
+	"miso/internal/storage/instrument"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogsOperations(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	s := instrument.New(&storage.MockStorage{
+		StatFunc: func(key string) (*storage.ObjectInfo, error) {
+			return nil, errors.New("access denied")
+		},
+	}, logger)
+
+	_, err := s.GetPresignedURL("modules/a/b/c/1.0.0/module.zip")
+	require.NoError(t, err)
+	_, err = s.Stat("modules/a/b/c/1.0.0/module.zip")
+	require.Error(t, err)
+
+	var lines []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]interface{}
+		require.NoError(t, dec.Decode(&line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 2)
+	assert.Equal(t, "DEBUG", lines[0]["level"])
+	assert.Equal(t, "GetPresignedURL", lines[0]["method"])
+	assert.Equal(t, "modules/a/b/c/1.0.0/module.zip", lines[0]["key"])
+	assert.Equal(t, "WARN", lines[1]["level"])
+	assert.Equal(t, "access denied", lines[1]["err"])
+}
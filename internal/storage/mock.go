@@ -1,14 +1,25 @@
 package storage
 
-import "io"
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
 
 // MockStorage is a mock implementation of the Storage interface.
 type MockStorage struct {
 	ListFunc            func(prefix string) ([]string, error)
 	GetPresignedURLFunc func(key string) (string, error)
 	GetStreamFunc       func(key string) (io.ReadCloser, error)
+	GetRangeFunc        func(key string, offset, length int64) (io.ReadCloser, error)
+	StatFunc            func(key string) (*ObjectInfo, error)
 	GetBufferFunc       func(key string) ([]byte, error)
 	PutFunc             func(key string, data io.Reader) error
+	DeleteFunc          func(key string) error
 }
 
 func (m *MockStorage) GetBuffer(key string) ([]byte, error) {
@@ -25,6 +36,35 @@ func (m *MockStorage) GetStream(key string) (io.ReadCloser, error) {
 	return nil, nil
 }
 
+// GetRange defaults to slicing the content returned by GetStreamFunc.
+func (m *MockStorage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	if m.GetRangeFunc != nil {
+		return m.GetRangeFunc(key, offset, length)
+	}
+	stream, err := m.GetStream(key)
+	if err != nil || stream == nil {
+		return stream, err
+	}
+	defer func() { _ = stream.Close() }()
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+	data = data[min(offset, int64(len(data))):]
+	if length >= 0 {
+		data = data[:min(length, int64(len(data)))]
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (m *MockStorage) Stat(key string) (*ObjectInfo, error) {
+	if m.StatFunc != nil {
+		return m.StatFunc(key)
+	}
+	return nil, nil
+}
+
 func (m *MockStorage) Put(key string, data io.Reader) error {
 	if m.PutFunc != nil {
 		return m.PutFunc(key, data)
@@ -33,6 +73,9 @@ func (m *MockStorage) Put(key string, data io.Reader) error {
 }
 
 func (m *MockStorage) Delete(key string) error {
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(key)
+	}
 	return nil
 }
 
@@ -49,3 +92,99 @@ func (m *MockStorage) GetPresignedURL(key string) (string, error) {
 	}
 	return "", nil
 }
+
+// MemoryStorage keeps objects in a map. Its MockStorage funcs are backed by
+// the map, so tests can still replace single calls, e.g. to count them or
+// make them fail.
+type MemoryStorage struct {
+	MockStorage
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	m := &MemoryStorage{objects: make(map[string][]byte)}
+	m.GetBufferFunc = func(key string) ([]byte, error) {
+		data, _ := m.Object(key)
+		return data, nil
+	}
+	m.GetStreamFunc = func(key string) (io.ReadCloser, error) {
+		data, ok := m.Object(key)
+		if !ok {
+			return nil, nil
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	m.StatFunc = func(key string) (*ObjectInfo, error) {
+		data, ok := m.Object(key)
+		if !ok {
+			return nil, nil
+		}
+		return &ObjectInfo{Size: int64(len(data)), ETag: etagOf(data)}, nil
+	}
+	m.PutFunc = func(key string, data io.Reader) error {
+		b, err := io.ReadAll(data)
+		if err != nil {
+			return err
+		}
+		m.SetObject(key, b)
+		return nil
+	}
+	m.DeleteFunc = func(key string) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.objects, key)
+		return nil
+	}
+	m.ListFunc = func(prefix string) ([]string, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		var keys []string
+		for key := range m.objects {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		return keys, nil
+	}
+	return m
+}
+
+// PutIf stores data under key if its ETag is still etag, or, with an empty
+// etag, if key does not exist.
+func (m *MemoryStorage) PutIf(key string, data io.Reader, etag string) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.objects[key]
+	if (etag == "" && ok) || (etag != "" && (!ok || etag != etagOf(current))) {
+		return ErrPreconditionFailed
+	}
+	m.objects[key] = b
+	return nil
+}
+
+// Object returns the content of key and whether it exists.
+func (m *MemoryStorage) Object(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	return data, ok
+}
+
+// SetObject stores data under key without going through PutFunc.
+func (m *MemoryStorage) SetObject(key string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
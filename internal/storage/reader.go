@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadSeeker exposes a stored object as an io.ReadSeeker, fetching byte
+// ranges on demand. It lets http.ServeContent answer range and conditional
+// requests without downloading the whole object first.
+type ReadSeeker struct {
+	storage Storage
+	key     string
+	info    *ObjectInfo
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+	// spans are the byte ranges the caller is expected to read, as
+	// [start, end) pairs.
+	spans [][2]int64
+}
+
+// NewReadSeeker reads key, whose metadata the caller got from Stat.
+func NewReadSeeker(storage Storage, key string, info *ObjectInfo) *ReadSeeker {
+	return &ReadSeeker{
+		storage: storage,
+		key:     key,
+		info:    info,
+		size:    info.Size,
+	}
+}
+
+// ExpectRanges tells r which byte ranges of an HTTP Range header the caller
+// is about to read, so that each is fetched with a request of its own
+// length rather than one reaching to the end of the object. Reads outside
+// the ranges still work, and invalid ranges are ignored.
+func (r *ReadSeeker) ExpectRanges(header string) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return
+	}
+	for _, part := range strings.Split(spec, ",") {
+		first, last, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			continue
+		}
+		start, end := int64(0), r.size
+		if first == "" {
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				continue
+			}
+			start = max(r.size-n, 0)
+		} else {
+			var err error
+			if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+				continue
+			}
+			if last != "" {
+				n, err := strconv.ParseInt(last, 10, 64)
+				if err != nil {
+					continue
+				}
+				end = min(n+1, r.size)
+			}
+		}
+		if start < end {
+			r.spans = append(r.spans, [2]int64{start, end})
+		}
+	}
+}
+
+// length returns how many bytes to fetch from the current offset: up to the
+// end of the expected range holding it, or else the rest of the object.
+func (r *ReadSeeker) length() int64 {
+	for _, span := range r.spans {
+		if span[0] <= r.offset && r.offset < span[1] {
+			return span[1] - r.offset
+		}
+	}
+	return -1
+}
+
+func (r *ReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := GetRangeOf(r.storage, r.key, r.info, r.offset, r.length())
+		if err != nil {
+			return 0, err
+		}
+		if body == nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && r.offset < r.size {
+		// The fetched range ended before the object did, e.g. because
+		// the caller read past an expected range; fetch the rest.
+		if err = r.Close(); err == nil && n == 0 {
+			return r.Read(p)
+		}
+	}
+	return n, err
+}
+
+func (r *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+
+	if offset != r.offset {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+// Close releases the current range request, if any.
+func (r *ReadSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,53 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"miso/internal/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// LoadAWSConfig builds the SDK configuration for cfg. Settings that are not
+// set in cfg fall back to the default AWS environment, shared config files
+// and credential chain.
+func LoadAWSConfig(ctx context.Context, cfg config.S3) (aws.Config, error) {
+	var opts []func(*awsConfig.LoadOptions) error
+
+	if cfg.Region != "" {
+		opts = append(opts, awsConfig.WithRegion(cfg.Region))
+	}
+	if cfg.AccessKeyID != "" || cfg.SecretAccessKey != "" {
+		opts = append(opts, awsConfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken),
+		))
+	}
+	if cfg.CABundle != "" {
+		bundle, err := os.Open(cfg.CABundle)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("s3.ca_bundle: %w", err)
+		}
+		defer func() { _ = bundle.Close() }()
+		opts = append(opts, awsConfig.WithCustomCABundle(bundle))
+	}
+
+	sdkConfig, err := awsConfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if cfg.AssumeRoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(sdkConfig), cfg.AssumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "miso"
+		})
+		sdkConfig.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	return sdkConfig, nil
+}
@@ -0,0 +1,37 @@
+package s3_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"miso/internal/config"
+	"miso/internal/storage/s3"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadAWSConfig(t *testing.T) {
+	t.Run("static credentials", func(t *testing.T) {
+		cfg, err := s3.LoadAWSConfig(context.Background(), config.S3{
+			Region:          "eu-central-1",
+			AccessKeyID:     "minio",
+			SecretAccessKey: "minio123",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "eu-central-1", cfg.Region)
+
+		creds, err := cfg.Credentials.Retrieve(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "minio", creds.AccessKeyID)
+		assert.Equal(t, "minio123", creds.SecretAccessKey)
+	})
+
+	t.Run("missing ca bundle", func(t *testing.T) {
+		_, err := s3.LoadAWSConfig(context.Background(), config.S3{
+			CABundle: filepath.Join(t.TempDir(), "ca.pem"),
+		})
+		assert.ErrorContains(t, err, "s3.ca_bundle")
+	})
+}
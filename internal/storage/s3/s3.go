@@ -3,33 +3,55 @@ package s3
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"miso/internal/config"
+	"miso/internal/storage"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 type Storage struct {
 	client         *s3.Client
 	presignClient  *s3.PresignClient
 	bucket         string
+	presignExpiry  atomic.Int64
 	requestTimeout time.Duration
 	transferClient *transfermanager.Client
 }
 
-func New(config config.S3, sdkConfig aws.Config) *Storage {
-	session := s3.NewFromConfig(sdkConfig)
-	return &Storage{
+func New(cfg config.S3, sdkConfig aws.Config) *Storage {
+	session := s3.NewFromConfig(sdkConfig, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.ForcePathStyle
+	})
+	s := &Storage{
 		client:         session,
 		presignClient:  s3.NewPresignClient(session),
-		bucket:         config.Bucket,
+		bucket:         cfg.Bucket,
 		transferClient: transfermanager.New(session),
 	}
+	s.SetPresignExpiry(cfg.PresignExpiry)
+	return s
+}
+
+// SetPresignExpiry changes the lifetime of presigned URLs created from now
+// on. Zero restores the default.
+func (s *Storage) SetPresignExpiry(expiry time.Duration) {
+	if expiry <= 0 {
+		expiry = config.DefaultPresignExpiry
+	}
+	s.presignExpiry.Store(int64(expiry))
 }
 
 func (s *Storage) requestContext() (context.Context, context.CancelFunc) {
@@ -39,6 +61,15 @@ func (s *Storage) requestContext() (context.Context, context.CancelFunc) {
 	return context.Background(), func() {}
 }
 
+// Ping checks that the bucket exists and is reachable with the configured
+// credentials.
+func (s *Storage) Ping(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: &s.bucket,
+	})
+	return err
+}
+
 func (s *Storage) GetBuffer(key string) ([]byte, error) {
 	var nsk *types.NoSuchKey
 
@@ -85,6 +116,63 @@ func (s *Storage) GetStream(key string) (io.ReadCloser, error) {
 	return resp.Body, err
 }
 
+func (s *Storage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	var nsk *types.NoSuchKey
+
+	if len(key) <= 0 {
+		return nil, nil
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+
+	rng := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length >= 0 {
+		rng += strconv.FormatInt(offset+length-1, 10)
+	}
+
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(key),
+		Range:  aws.String(rng),
+	})
+	if errors.As(err, &nsk) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *Storage) Stat(key string) (*storage.ObjectInfo, error) {
+	var nf *types.NotFound
+
+	if len(key) <= 0 {
+		return nil, nil
+	}
+	ctx, cancel := s.requestContext()
+	defer cancel()
+
+	resp, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(key),
+	})
+	if errors.As(err, &nf) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage.ObjectInfo{
+		Size:         aws.ToInt64(resp.ContentLength),
+		ETag:         aws.ToString(resp.ETag),
+		LastModified: aws.ToTime(resp.LastModified),
+		ContentType:  aws.ToString(resp.ContentType),
+	}, nil
+}
+
 func (s *Storage) Put(key string, data io.Reader) error {
 	if len(key) <= 0 {
 		return nil
@@ -102,6 +190,35 @@ func (s *Storage) Put(key string, data io.Reader) error {
 	return err
 }
 
+// PutIf writes key with If-Match, or with If-None-Match: * when etag is
+// empty, and reports ErrPreconditionFailed when the object was changed.
+func (s *Storage) PutIf(key string, data io.Reader, etag string) error {
+	if len(key) <= 0 {
+		return nil
+	}
+
+	ctx, cancel := s.requestContext()
+	defer cancel()
+
+	input := &transfermanager.UploadObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(key),
+		Body:   data,
+	}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+	_, err := s.transferClient.UploadObject(ctx, input)
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict") {
+		return fmt.Errorf("%w: %s", storage.ErrPreconditionFailed, key)
+	}
+	return err
+}
+
 func (s *Storage) Delete(key string) error {
 	if len(key) <= 0 {
 		return nil
@@ -157,7 +274,7 @@ func (s *Storage) GetPresignedURL(key string) (string, error) {
 		Bucket: &s.bucket,
 		Key:    aws.String(key),
 	}, func(opts *s3.PresignOptions) {
-		opts.Expires = time.Duration(10 * time.Minute)
+		opts.Expires = time.Duration(s.presignExpiry.Load())
 	})
 	if err != nil {
 		return "", err
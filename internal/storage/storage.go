@@ -1,12 +1,77 @@
 package storage
 
-import "io"
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
 
 type Storage interface {
 	GetBuffer(key string) ([]byte, error)
 	GetStream(key string) (io.ReadCloser, error)
+	// GetRange streams length bytes of an object starting at offset. A
+	// negative length reads until the end of the object.
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+	// Stat returns the metadata of an object, or nil if it does not exist.
+	Stat(key string) (*ObjectInfo, error)
 	Put(key string, data io.Reader) error
 	Delete(key string) error
 	List(path string) ([]string, error)
 	GetPresignedURL(key string) (string, error)
 }
+
+// ObjectInfo is the metadata of a stored object.
+type ObjectInfo struct {
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+}
+
+// ContextStorage is implemented by storage that attributes calls to the
+// request they are made for, e.g. to trace them.
+type ContextStorage interface {
+	WithContext(ctx context.Context) Storage
+}
+
+// StatReader is implemented by storage that can read an object whose
+// metadata the caller already has, such as a cache that would otherwise
+// Stat the object again to find its current version.
+type StatReader interface {
+	// GetRangeOf reads like GetRange, trusting info as the current
+	// metadata of key.
+	GetRangeOf(key string, info *ObjectInfo, offset, length int64) (io.ReadCloser, error)
+}
+
+// GetRangeOf reads a range of key through s, passing on info when s can use
+// it, see StatReader.
+func GetRangeOf(s Storage, key string, info *ObjectInfo, offset, length int64) (io.ReadCloser, error) {
+	if reader, ok := s.(StatReader); ok {
+		return reader.GetRangeOf(key, info, offset, length)
+	}
+	return s.GetRange(key, offset, length)
+}
+
+// ErrPreconditionFailed is returned by PutIf when the object was changed by
+// another writer.
+var ErrPreconditionFailed = errors.New("object was changed by another writer")
+
+// ConditionalStorage is implemented by storage that writes an object only
+// when it is unchanged, so that writers in different processes do not
+// overwrite each other.
+type ConditionalStorage interface {
+	// PutIf writes key if its ETag is still etag, or, when etag is empty,
+	// if it does not exist yet.
+	PutIf(key string, data io.Reader, etag string) error
+}
+
+// PutIf writes key through s only if it is unchanged, see
+// ConditionalStorage. Storage without conditional writes falls back to a
+// plain Put, which protects against nothing.
+func PutIf(s Storage, key string, data io.Reader, etag string) error {
+	if conditional, ok := s.(ConditionalStorage); ok {
+		return conditional.PutIf(key, data, etag)
+	}
+	return s.Put(key, data)
+}
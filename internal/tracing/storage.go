@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"miso/internal/storage"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Storage creates a span for every call to the wrapped backend. Calls are
+// attached to the context given to WithContext, so that they show up as
+// children of the request they are made for.
+type Storage struct {
+	backend storage.Storage
+	bucket  string
+	ctx     context.Context
+}
+
+func NewStorage(backend storage.Storage, bucket string) *Storage {
+	return &Storage{backend: backend, bucket: bucket, ctx: context.Background()}
+}
+
+// WithContext returns a copy of s whose spans are children of the span in
+// ctx. The context is passed on to the backend when it uses one too.
+func (s *Storage) WithContext(ctx context.Context) storage.Storage {
+	scoped := *s
+	scoped.ctx = ctx
+	if backend, ok := s.backend.(storage.ContextStorage); ok {
+		scoped.backend = backend.WithContext(ctx)
+	}
+	return &scoped
+}
+
+func (s *Storage) start(method, key string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := tracer().Start(s.ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs,
+			semconv.AWSS3Bucket(s.bucket),
+			semconv.AWSS3Key(key),
+		)...),
+	)
+	return span
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *Storage) GetBuffer(key string) ([]byte, error) {
+	span := s.start("GetBuffer", key)
+	data, err := s.backend.GetBuffer(key)
+	end(span, err)
+	return data, err
+}
+
+func (s *Storage) GetStream(key string) (io.ReadCloser, error) {
+	span := s.start("GetStream", key)
+	r, err := s.backend.GetStream(key)
+	end(span, err)
+	return r, err
+}
+
+func (s *Storage) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	span := s.start("GetRange", key,
+		attribute.Int64("storage.offset", offset),
+		attribute.Int64("storage.length", length),
+	)
+	r, err := s.backend.GetRange(key, offset, length)
+	end(span, err)
+	return r, err
+}
+
+// GetRangeOf forwards the metadata the caller already has to the backend.
+func (s *Storage) GetRangeOf(key string, info *storage.ObjectInfo, offset, length int64) (io.ReadCloser, error) {
+	span := s.start("GetRangeOf", key,
+		attribute.Int64("storage.offset", offset),
+		attribute.Int64("storage.length", length),
+	)
+	r, err := storage.GetRangeOf(s.backend, key, info, offset, length)
+	end(span, err)
+	return r, err
+}
+
+func (s *Storage) Stat(key string) (*storage.ObjectInfo, error) {
+	span := s.start("Stat", key)
+	info, err := s.backend.Stat(key)
+	end(span, err)
+	return info, err
+}
+
+func (s *Storage) Put(key string, data io.Reader) error {
+	span := s.start("Put", key)
+	err := s.backend.Put(key, data)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) PutIf(key string, data io.Reader, etag string) error {
+	span := s.start("PutIf", key)
+	err := storage.PutIf(s.backend, key, data, etag)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) Delete(key string) error {
+	span := s.start("Delete", key)
+	err := s.backend.Delete(key)
+	end(span, err)
+	return err
+}
+
+func (s *Storage) List(path string) ([]string, error) {
+	span := s.start("List", path)
+	keys, err := s.backend.List(path)
+	if err == nil {
+		span.SetAttributes(attribute.Int("storage.keys", len(keys)))
+	}
+	end(span, err)
+	return keys, err
+}
+
+func (s *Storage) GetPresignedURL(key string) (string, error) {
+	span := s.start("GetPresignedURL", key)
+	u, err := s.backend.GetPresignedURL(key)
+	end(span, err)
+	return u, err
+}
@@ -0,0 +1,90 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"miso/internal/config"
+	"miso/internal/storage"
+	"miso/internal/tracing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRequestAndStorageSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	_, err := tracing.Setup(t.Context(), config.Tracing{})
+	require.NoError(t, err)
+
+	s := tracing.NewStorage(&storage.MockStorage{
+		ListFunc: func(path string) ([]string, error) {
+			return []string{path + "1.0.0/module.zip"}, nil
+		},
+	}, "miso-dev")
+
+	e := echo.New()
+	e.Use(tracing.Middleware())
+	e.GET("/v1/modules/:namespace/:name/:provider/versions", func(c echo.Context) error {
+		_, err := s.WithContext(c.Request().Context()).List("modules/acme/vpc/aws/")
+		if err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/modules/acme/vpc/aws/versions", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	storageSpan, requestSpan := spans[0], spans[1]
+
+	assert.Equal(t, "GET /v1/modules/:namespace/:name/:provider/versions", requestSpan.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", requestSpan.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", requestSpan.Parent.SpanID().String())
+	assert.Contains(t, requestSpan.Attributes, attribute.Int("http.response.status_code", http.StatusOK))
+
+	assert.Equal(t, "storage.List", storageSpan.Name)
+	assert.Equal(t, requestSpan.SpanContext.SpanID(), storageSpan.Parent.SpanID())
+	assert.Contains(t, storageSpan.Attributes, attribute.String("aws.s3.bucket", "miso-dev"))
+	assert.Contains(t, storageSpan.Attributes, attribute.String("aws.s3.key", "modules/acme/vpc/aws/"))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, tracing.Validate(config.Tracing{Exporter: "otlp-grpc", SampleRatio: 0.5}))
+	assert.Error(t, tracing.Validate(config.Tracing{Exporter: "zipkin"}))
+	assert.Error(t, tracing.Validate(config.Tracing{SampleRatio: 2}))
+}
+
+// contextStorage records the context it is scoped to.
+type contextStorage struct {
+	storage.MockStorage
+	ctx context.Context
+}
+
+func (s *contextStorage) WithContext(ctx context.Context) storage.Storage {
+	s.ctx = ctx
+	return s
+}
+
+func TestStorageContextPassedOn(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(t.Context(), key{}, "request")
+
+	backend := &contextStorage{}
+	tracing.NewStorage(backend, "miso-dev").WithContext(ctx)
+	require.NotNil(t, backend.ctx)
+	assert.Equal(t, "request", backend.ctx.Value(key{}))
+}
@@ -0,0 +1,249 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"miso/internal/config"
+	"miso/internal/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var deliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "miso_webhook_deliveries_total",
+	Help: "Webhook deliveries by result: delivered, failed after every attempt, or dropped because the queue was full.",
+}, []string{"result"})
+
+const (
+	workers   = 4
+	queueSize = 1000
+
+	deadLetterPrefix = "webhooks/dead-letter/"
+)
+
+// ErrClosed is returned when an event is published after Close or Drain.
+var ErrClosed = errors.New("webhook dispatcher is closed")
+
+// DeadLetter records a delivery that failed every attempt.
+type DeadLetter struct {
+	URL      string    `json:"url"`
+	Event    Event     `json:"event"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+type delivery struct {
+	hook  config.Webhook
+	event Event
+	body  []byte
+}
+
+// Dispatcher delivers events to the subscribed webhooks in the background,
+// retrying failed deliveries with exponential backoff.
+type Dispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	// deadLetters keeps the deliveries that failed every attempt.
+	deadLetters storage.Storage
+	logger      *slog.Logger
+
+	hooks      atomic.Pointer[[]config.Webhook]
+	deliveries chan delivery
+	closing    chan struct{}
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+
+	// mu guards closed, so that no event is queued once deliveries is
+	// closed.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewDispatcher(cfg config.Webhooks, deadLetters storage.Storage, logger *slog.Logger) *Dispatcher {
+	d := &Dispatcher{
+		client:      &http.Client{Timeout: cfg.Timeout},
+		maxAttempts: max(cfg.MaxAttempts, 1),
+		backoff:     cfg.Backoff,
+		deadLetters: deadLetters,
+		logger:      logger,
+		deliveries:  make(chan delivery, queueSize),
+		closing:     make(chan struct{}),
+	}
+	d.SetHooks(cfg.Hooks)
+
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer d.wg.Done()
+			for dl := range d.deliveries {
+				d.deliver(dl)
+			}
+		}()
+	}
+	return d
+}
+
+// SetHooks replaces the webhooks that receive new events.
+func (d *Dispatcher) SetHooks(hooks []config.Webhook) {
+	d.hooks.Store(&hooks)
+}
+
+// Publish queues e for every webhook subscribed to it, filling in its ID
+// and time when they are unset. It returns ErrClosed after Close or Drain.
+func (d *Dispatcher) Publish(e Event) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return ErrClosed
+	}
+
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range *d.hooks.Load() {
+		if !subscribed(hook, e) {
+			continue
+		}
+		select {
+		case d.deliveries <- delivery{hook: hook, event: e, body: body}:
+		default:
+			deliveriesTotal.WithLabelValues("dropped").Inc()
+			d.logger.Warn("webhook queue full, dropping delivery", slog.String("event", e.Type), slog.String("url", hook.URL))
+		}
+	}
+	return nil
+}
+
+// Close stops retrying and waits for the queued deliveries to be tried
+// once more. Deliveries that still fail go to the dead letters.
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(func() { close(d.closing) })
+	d.Drain()
+}
+
+// Drain stops accepting events and waits for the queued deliveries,
+// retrying them as usual. The CLI drains before it exits.
+func (d *Dispatcher) Drain() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.deliveries)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(dl delivery) {
+	var err error
+	attempts := 0
+	for attempts < d.maxAttempts {
+		attempts++
+		if err = d.send(dl); err == nil {
+			deliveriesTotal.WithLabelValues("delivered").Inc()
+			return
+		}
+		if attempts == d.maxAttempts || !d.wait(d.backoff<<(attempts-1)) {
+			break
+		}
+	}
+
+	deliveriesTotal.WithLabelValues("failed").Inc()
+	d.logger.Warn("webhook delivery failed",
+		slog.String("event", dl.event.Type),
+		slog.String("delivery", dl.event.ID),
+		slog.String("url", dl.hook.URL),
+		slog.Int("attempts", attempts),
+		slog.String("err", err.Error()),
+	)
+	d.deadLetter(DeadLetter{
+		URL:      dl.hook.URL,
+		Event:    dl.event,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Time:     time.Now().UTC(),
+	})
+}
+
+// wait sleeps for backoff and reports whether the delivery should be
+// retried, which it should not once the dispatcher is closing.
+func (d *Dispatcher) wait(backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-d.closing:
+		return false
+	}
+}
+
+func (d *Dispatcher) send(dl delivery) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, dl.hook.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "miso")
+	req.Header.Set(HeaderEvent, dl.event.Type)
+	req.Header.Set(HeaderDelivery, dl.event.ID)
+	req.Header.Set(HeaderSignature, Sign(dl.hook.Secret, dl.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (d *Dispatcher) deadLetter(dl DeadLetter) {
+	if d.deadLetters == nil {
+		return
+	}
+	data, err := json.Marshal(dl)
+	if err == nil {
+		key := deadLetterPrefix + dl.Time.Format("2006/01/02/") + newID() + ".json"
+		err = d.deadLetters.Put(key, bytes.NewReader(data))
+	}
+	if err != nil {
+		d.logger.Error("could not store webhook dead letter",
+			slog.String("delivery", dl.Event.ID),
+			slog.String("url", dl.URL),
+			slog.String("err", err.Error()),
+		)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
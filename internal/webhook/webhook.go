@@ -0,0 +1,97 @@
+// Package webhook notifies subscribers of changes to the registry.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"miso/internal/config"
+)
+
+const (
+	ModulePublished   = "module.published"
+	ProviderPublished = "provider.published"
+	VersionDeleted    = "version.deleted"
+	VersionYanked     = "version.yanked"
+)
+
+var eventTypes = []string{ModulePublished, ProviderPublished, VersionDeleted, VersionYanked}
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256 of
+// the body keyed with the webhook secret, prefixed with "sha256=".
+const (
+	HeaderEvent     = "X-Miso-Event"
+	HeaderDelivery  = "X-Miso-Delivery"
+	HeaderSignature = "X-Miso-Signature"
+)
+
+// Event is the payload of a delivery. Name is the module name or the
+// provider type; Provider is only set for modules and OS and Arch only for
+// provider platforms.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Provider  string    `json:"provider,omitempty"`
+	Version   string    `json:"version"`
+	OS        string    `json:"os,omitempty"`
+	Arch      string    `json:"arch,omitempty"`
+	Principal string    `json:"principal,omitempty"`
+}
+
+// Sign returns the signature header value of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// subscribed reports whether hook receives e.
+func subscribed(hook config.Webhook, e Event) bool {
+	if hook.Namespace != "" && hook.Namespace != e.Namespace {
+		return false
+	}
+	return len(hook.Events) == 0 || slices.Contains(hook.Events, e.Type)
+}
+
+// Validate checks the webhook settings.
+func Validate(cfg config.Webhooks) error {
+	var errs []error
+
+	for i, hook := range cfg.Hooks {
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhooks.hooks[%d].url: must be an http or https URL", i))
+		}
+		if hook.Secret == "" {
+			errs = append(errs, fmt.Errorf("webhooks.hooks[%d].secret: required", i))
+		}
+		for _, event := range hook.Events {
+			if !slices.Contains(eventTypes, event) {
+				errs = append(errs, fmt.Errorf("webhooks.hooks[%d].events: unknown event %q", i, event))
+			}
+		}
+	}
+	if len(cfg.Hooks) > 0 {
+		if cfg.MaxAttempts <= 0 {
+			errs = append(errs, errors.New("webhooks.max_attempts: must be positive"))
+		}
+		if cfg.Backoff <= 0 {
+			errs = append(errs, errors.New("webhooks.backoff: must be positive"))
+		}
+		if cfg.Timeout <= 0 {
+			errs = append(errs, errors.New("webhooks.timeout: must be positive"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"miso/internal/config"
+	"miso/internal/storage"
+	"miso/internal/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records the deliveries it accepts, failing the first failures
+// requests.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if r.requests <= r.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	r.received = append(r.received, req)
+	r.bodies = append(r.bodies, body)
+}
+
+func TestDelivery(t *testing.T) {
+	r := &receiver{failures: 2}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	d := webhook.NewDispatcher(config.Webhooks{
+		Hooks: []config.Webhook{
+			{URL: server.URL, Namespace: "acme", Events: []string{webhook.ModulePublished}, Secret: "s3cret"},
+		},
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Timeout:     time.Second,
+	}, nil, slog.Default())
+
+	d.Publish(webhook.Event{Type: webhook.ModulePublished, Kind: "module", Namespace: "acme", Name: "vpc", Provider: "aws", Version: "1.0.0"})
+	d.Publish(webhook.Event{Type: webhook.ModulePublished, Kind: "module", Namespace: "other", Name: "vpc", Provider: "aws", Version: "1.0.0"})
+	d.Publish(webhook.Event{Type: webhook.VersionDeleted, Kind: "module", Namespace: "acme", Name: "vpc", Provider: "aws", Version: "0.9.0"})
+	require.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.received) > 0
+	}, time.Second, time.Millisecond)
+	d.Close()
+
+	require.Len(t, r.received, 1)
+	assert.Equal(t, 3, r.requests)
+
+	req, body := r.received[0], r.bodies[0]
+	assert.Equal(t, webhook.ModulePublished, req.Header.Get(webhook.HeaderEvent))
+	assert.Equal(t, webhook.Sign("s3cret", body), req.Header.Get(webhook.HeaderSignature))
+
+	var e webhook.Event
+	require.NoError(t, json.Unmarshal(body, &e))
+	assert.Equal(t, req.Header.Get(webhook.HeaderDelivery), e.ID)
+	assert.Equal(t, "1.0.0", e.Version)
+	assert.False(t, e.Time.IsZero())
+}
+
+func TestDeadLetter(t *testing.T) {
+	server := httptest.NewServer(&receiver{failures: 10})
+	defer server.Close()
+
+	s := storage.NewMemoryStorage()
+
+	d := webhook.NewDispatcher(config.Webhooks{
+		Hooks:       []config.Webhook{{URL: server.URL, Secret: "s3cret"}},
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+		Timeout:     time.Second,
+	}, s, slog.Default())
+	d.Publish(webhook.Event{Type: webhook.VersionDeleted, Kind: "provider", Namespace: "acme", Name: "widget", Version: "0.4.1"})
+	require.Eventually(t, func() bool {
+		keys, _ := s.List("webhooks/dead-letter/")
+		return len(keys) > 0
+	}, time.Second, time.Millisecond)
+	d.Close()
+
+	keys, err := s.List("webhooks/dead-letter/")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	data, _ := s.Object(keys[0])
+
+	var letter webhook.DeadLetter
+	require.NoError(t, json.Unmarshal(data, &letter))
+	assert.Equal(t, server.URL, letter.URL)
+	assert.Equal(t, 2, letter.Attempts)
+	assert.Equal(t, "unexpected status 503 Service Unavailable", letter.Error)
+	assert.Equal(t, "widget", letter.Event.Name)
+}
+
+func TestClose(t *testing.T) {
+	rec := &receiver{failures: 1}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	d := webhook.NewDispatcher(config.Webhooks{
+		Hooks:       []config.Webhook{{URL: server.URL, Secret: "s3cret"}},
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+		Timeout:     time.Second,
+	}, nil, slog.Default())
+	require.NoError(t, d.Publish(webhook.Event{Type: webhook.VersionDeleted, Kind: "provider", Namespace: "acme", Name: "widget", Version: "0.4.1"}))
+
+	// Drain retries the queued deliveries.
+	d.Drain()
+	rec.mu.Lock()
+	assert.Len(t, rec.received, 1)
+	rec.mu.Unlock()
+
+	assert.ErrorIs(t, d.Publish(webhook.Event{Type: webhook.VersionDeleted}), webhook.ErrClosed)
+	d.Close()
+	assert.ErrorIs(t, d.Publish(webhook.Event{Type: webhook.VersionDeleted}), webhook.ErrClosed)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, webhook.Validate(config.Webhooks{}))
+
+	err := webhook.Validate(config.Webhooks{
+		Hooks: []config.Webhook{
+			{URL: "ftp://hooks.example.com", Events: []string{"module.updated", "version.yanked"}},
+		},
+	})
+	assert.ErrorContains(t, err, "webhooks.hooks[0].url: must be an http or https URL")
+	assert.ErrorContains(t, err, "webhooks.hooks[0].secret: required")
+	assert.ErrorContains(t, err, `webhooks.hooks[0].events: unknown event "module.updated"`)
+	assert.NotContains(t, err.Error(), "version.yanked")
+	assert.ErrorContains(t, err, "webhooks.max_attempts: must be positive")
+}